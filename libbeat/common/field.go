@@ -18,11 +18,30 @@
 package common
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/joeshaw/multierror"
 	"github.com/pkg/errors"
+	yamlv2 "gopkg.in/yaml.v2"
 
+	"github.com/elastic/beats/libbeat/logp"
+	ucfg "github.com/elastic/go-ucfg"
 	"github.com/elastic/go-ucfg/yaml"
 )
 
@@ -36,26 +55,42 @@ import (
 type Fields []Field
 
 type Field struct {
-	Name           string      `config:"name"`
-	Type           string      `config:"type"`
-	Description    string      `config:"description"`
-	Format         string      `config:"format"`
-	Fields         Fields      `config:"fields"`
-	MultiFields    Fields      `config:"multi_fields"`
-	Enabled        *bool       `config:"enabled"`
-	Analyzer       string      `config:"analyzer"`
-	SearchAnalyzer string      `config:"search_analyzer"`
-	Norms          bool        `config:"norms"`
-	Dynamic        DynamicType `config:"dynamic"`
-	Index          *bool       `config:"index"`
-	DocValues      *bool       `config:"doc_values"`
-	CopyTo         string      `config:"copy_to"`
-	IgnoreAbove    int         `config:"ignore_above"`
-	AliasPath      string      `config:"path"`
+	Name           string `config:"name"`
+	Type           string `config:"type"`
+	Description    string `config:"description"`
+	Format         string `config:"format"`
+	Fields         Fields `config:"fields"`
+	MultiFields    Fields `config:"multi_fields"`
+	Enabled        *bool  `config:"enabled"`
+	Analyzer       string `config:"analyzer"`
+	SearchAnalyzer string `config:"search_analyzer"`
+	Norms          *bool  `config:"norms"`
+	Similarity     string `config:"similarity"`
+	TermVector     string `config:"term_vector"`
+	// Boost is the index-time boost of text and keyword fields. It is
+	// deprecated since Elasticsearch 5.0 and not added to mappings for
+	// Elasticsearch 8.0 and later, prefer boosting fields at query time.
+	Boost           *float64    `config:"boost"`
+	Dynamic         DynamicType `config:"dynamic"`
+	Index           *bool       `config:"index"`
+	DocValues       *bool       `config:"doc_values"`
+	Coerce          *bool       `config:"coerce"`
+	IgnoreMalformed *bool       `config:"ignore_malformed"`
+	CopyTo          []string    `config:"copy_to"`
+	IgnoreAbove     int         `config:"ignore_above"`
+	AliasPath       string      `config:"path"`
+	DefaultField    *bool       `config:"default_field"`
+	DateFormat      string      `config:"date_format"`
+
+	// Time series data stream settings, TimeSeriesMetric is the metric type
+	// of numeric fields and TimeSeriesDimension marks keyword fields
+	// identifying the time series.
+	TimeSeriesMetric    string `config:"time_series_metric"`
+	TimeSeriesDimension *bool  `config:"time_series_dimension"`
 
 	ObjectType            string          `config:"object_type"`
 	ObjectTypeMappingType string          `config:"object_type_mapping_type"`
-	ScalingFactor         int             `config:"scaling_factor"`
+	ScalingFactor         float64         `config:"scaling_factor"`
 	ObjectTypeParams      []ObjectTypeCfg `config:"object_type_params"`
 
 	// Kibana specific
@@ -73,15 +108,95 @@ type Field struct {
 	UrlTemplate          []VersionizedString `config:"url_template"`
 	OpenLinkInCurrentTab *bool               `config:"open_link_in_current_tab"`
 
+	// Meta holds arbitrary metadata, like ownership, that is kept with the
+	// field but not used for mappings.
+	Meta MapStr `config:"meta"`
+
+	// Overrides holds attributes replacing the ones of the field when
+	// generating the mapping for an Elasticsearch major version, keyed by the
+	// version prefixed with v (e.g. `v6`).
+	Overrides map[string]Field `config:"overrides"`
+
 	Overwrite bool `config:"overwrite"`
 	Path      string
 }
 
 // ObjectTypeCfg defines type and configuration of object attributes
 type ObjectTypeCfg struct {
-	ObjectType            string `config:"object_type"`
-	ObjectTypeMappingType string `config:"object_type_mapping_type"`
-	ScalingFactor         int    `config:"scaling_factor"`
+	ObjectType            string  `config:"object_type"`
+	ObjectTypeMappingType string  `config:"object_type_mapping_type"`
+	ScalingFactor         float64 `config:"scaling_factor"`
+
+	// MatchPattern restricts the configuration to the keys of the object
+	// matching the pattern (e.g. `*.pct`), used as path_match of the dynamic
+	// template relative to the object.
+	MatchPattern string `config:"match_pattern"`
+}
+
+// MatchMappingType returns the match_mapping_type of the dynamic template
+// generated for this configuration. If no object_type_mapping_type is set, a
+// default is derived from the object type, unless a match pattern is set, in
+// which case values of any type are matched and an empty string is returned.
+func (c ObjectTypeCfg) MatchMappingType() string {
+	if c.ObjectTypeMappingType != "" {
+		return c.ObjectTypeMappingType
+	}
+	if c.MatchPattern != "" {
+		return ""
+	}
+	switch c.ObjectType {
+	case "scaled_float":
+		return "*"
+	case "text", "keyword":
+		return "string"
+	default:
+		return c.ObjectType
+	}
+}
+
+// matchMappingTypes are the accepted values for object_type_mapping_type.
+// Besides the JSON types Elasticsearch can detect, float is accepted as it is
+// in use for scaled_float objects.
+var matchMappingTypes = []string{"*", "binary", "boolean", "date", "double", "float", "long", "object", "string"}
+
+// Validate ensures the configuration matches values by mapping type or by
+// pattern, that the object_type_mapping_type is a known match_mapping_type and
+// the scaling factor is positive.
+func (c *ObjectTypeCfg) Validate() error {
+	if c.MatchMappingType() == "" && c.MatchPattern == "" {
+		return errors.New("object type configuration requires an object_type, object_type_mapping_type or match_pattern")
+	}
+	if err := validateScalingFactor(c.ScalingFactor); err != nil {
+		return err
+	}
+	return validateMatchMappingType(c.ObjectTypeMappingType)
+}
+
+// validateScalingFactor ensures a scaling factor is not negative. A scaling
+// factor of 0 can not be told apart from an unset one, so it is accepted and
+// means the default scaling factor is used in the template.
+func validateScalingFactor(scalingFactor float64) error {
+	if scalingFactor < 0 {
+		return errors.Errorf("scaling_factor must be positive, got %v", scalingFactor)
+	}
+	return nil
+}
+
+func validateMatchMappingType(mappingType string) error {
+	if mappingType == "" || containsString(matchMappingTypes, mappingType) {
+		return nil
+	}
+	return errors.Errorf("invalid object_type_mapping_type '%s', expected one of: %s",
+		mappingType, strings.Join(matchMappingTypes, ", "))
+}
+
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
 }
 
 type VersionizedString struct {
@@ -89,8 +204,17 @@ type VersionizedString struct {
 	Value      string `config:"value"`
 }
 
+// DynamicType holds the dynamic setting of a field. Value is nil if the
+// setting is not specified, so the field inherits the setting of its parent,
+// and true, false or "strict" otherwise.
 type DynamicType struct{ Value interface{} }
 
+// IsSet returns true if the dynamic setting is specified, this includes an
+// explicit false.
+func (d DynamicType) IsSet() bool {
+	return d.Value != nil
+}
+
 func (d *DynamicType) Unpack(s string) error {
 	switch s {
 	case "true":
@@ -105,137 +229,2439 @@ func (d *DynamicType) Unpack(s string) error {
 	return nil
 }
 
-// Validate ensures objectTypeParams are not mixed with top level objectType configuration
-func (f *Field) Validate() error {
-	if len(f.ObjectTypeParams) == 0 {
-		return nil
+// String returns the dynamic setting as it is written in the fields.yml, or
+// an empty string if it is not set.
+func (d DynamicType) String() string {
+	switch v := d.Value.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	default:
+		return ""
+	}
+}
+
+// MarshalYAML implements the yaml.Marshaler interface, writing the dynamic
+// setting in the same form it is unpacked from.
+func (d DynamicType) MarshalYAML() (interface{}, error) {
+	return d.Value, nil
+}
+
+// MarshalJSON implements the json.Marshaler interface, writing the dynamic
+// setting in the same form it is unpacked from.
+func (d DynamicType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Value)
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface, accepting the same
+// values as Unpack, as a boolean or a string.
+func (d *DynamicType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	return d.unmarshal(v)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, accepting the same
+// values as Unpack, as a boolean or a string.
+func (d *DynamicType) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
 	}
-	if f.ScalingFactor != 0 || f.ObjectTypeMappingType != "" || f.ObjectType != "" {
-		return errors.New("mixing top level objectType configuration with array of object type configurations is forbidden")
+	return d.unmarshal(v)
+}
+
+func (d *DynamicType) unmarshal(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		d.Value = nil
+	case bool:
+		d.Value = v
+	case string:
+		return d.Unpack(v)
+	default:
+		return fmt.Errorf("'%v' is invalid dynamic setting", v)
 	}
 	return nil
 }
 
-func LoadFieldsYaml(path string) (Fields, error) {
-	keys := []Field{}
+// IsMultiField returns true if the field declares multi-fields, indexing the
+// same value with different mappings (e.g. message.keyword).
+func (f Field) IsMultiField() bool {
+	return len(f.MultiFields) > 0
+}
 
-	cfg, err := yaml.NewConfigWithFile(path)
-	if err != nil {
-		return nil, err
+// ObjectTypeConfigs returns the object type configurations of the field. If
+// object_type_params is not set, a single configuration is built from the top
+// level object type settings.
+func (f Field) ObjectTypeConfigs() []ObjectTypeCfg {
+	if len(f.ObjectTypeParams) != 0 {
+		return f.ObjectTypeParams
 	}
-	cfg.Unpack(&keys)
+	return []ObjectTypeCfg{{
+		ObjectType:            f.ObjectType,
+		ObjectTypeMappingType: f.ObjectTypeMappingType,
+		ScalingFactor:         f.ScalingFactor,
+	}}
+}
 
-	fields := Fields{}
+// ObjectTypeFor returns the object type configuration applied to values with
+// the given mapping type. Configurations with a matching mapping type take
+// precedence over the ones matching all types.
+func (f Field) ObjectTypeFor(mappingType string) (ObjectTypeCfg, bool) {
+	var (
+		wildcard ObjectTypeCfg
+		found    bool
+	)
+	for _, cfg := range f.ObjectTypeConfigs() {
+		switch cfg.MatchMappingType() {
+		case mappingType:
+			return cfg, true
+		case "*":
+			if !found {
+				wildcard, found = cfg, true
+			}
+		}
+	}
+	return wildcard, found
+}
 
-	for _, key := range keys {
-		fields = append(fields, key.Fields...)
+// IsDefaultField returns true if the field is included in the fields
+// queried by default. Only keyword and text fields which are indexed are
+// included, unless default_field is set to false.
+func (f Field) IsDefaultField() bool {
+	switch f.Type {
+	case "", "keyword", "text":
+	default:
+		return false
 	}
-	return fields, nil
+	if f.Index != nil && !*f.Index {
+		return false
+	}
+	return f.DefaultField == nil || *f.DefaultField
 }
 
-// HasKey checks if inside fields the given key exists
-// The key can be in the form of a.b.c and it will check if the nested field exist
-// In case the key is `a` and there is a value `a.b` false is return as it only
-// returns true if it's a leave node
-func (f Fields) HasKey(key string) bool {
-	keys := strings.Split(key, ".")
-	return f.hasKey(keys)
+// deprecatedTypes maps deprecated field types to the type replacing them.
+var deprecatedTypes = map[string]string{
+	"string": "keyword",
 }
 
-// HasNode checks if inside fields the given node exists
-// In contrast to HasKey it not only compares the leaf nodes but
-// every single key it traverses.
-func (f Fields) HasNode(key string) bool {
-	keys := strings.Split(key, ".")
-	return f.hasNode(keys)
+// NormalizedType returns the type of the field, with deprecated types
+// replaced by their current equivalent.
+func (f Field) NormalizedType() string {
+	if t, found := deprecatedTypes[f.Type]; found {
+		return t
+	}
+	return f.Type
 }
 
-func (f Fields) hasNode(keys []string) bool {
+// normalizeTypes handles the deprecated types of the loaded fields, including
+// multi-fields and overrides, as configured by the load options. They are
+// replaced by their current equivalent with a deprecation warning, or reported
+// as errors.
+func (f Fields) normalizeTypes(namespace string, options loadOptions, errs *multierror.Errors) {
+	for i := range f {
+		field := &f[i]
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+		if err := field.normalizeType(fieldName, options); err != nil {
+			*errs = append(*errs, err)
+		}
+		for version, override := range field.Overrides {
+			if err := override.normalizeType(fieldName, options); err != nil {
+				*errs = append(*errs, err)
+			}
+			field.Overrides[version] = override
+		}
+		field.Fields.normalizeTypes(fieldName, options, errs)
+		field.MultiFields.normalizeTypes(fieldName, options, errs)
+	}
+}
 
-	// Nothing to compare, so does not contain it
-	if len(keys) == 0 {
-		return false
+func (f *Field) normalizeType(key string, options loadOptions) error {
+	t := f.NormalizedType()
+	if t == f.Type {
+		return nil
 	}
+	switch {
+	case options.rejectDeprecatedTypes:
+		return errors.Errorf("type '%s' of field '%s' is deprecated, use '%s' instead", f.Type, key, t)
+	case options.normalizeDeprecatedTypes:
+		logp.Warn("DEPRECATED: Field '%s' uses type '%s', use '%s' instead", key, f.Type, t)
+		f.Type = t
+	}
+	return nil
+}
 
-	key := keys[0]
-	keys = keys[1:]
+// Validate ensures the settings of the field are valid for its type.
+func (f *Field) Validate() error {
+	if t := f.NormalizedType(); t != f.Type {
+		// Deprecated types are validated like the type replacing them.
+		normalized := *f
+		normalized.Type = t
+		return normalized.validate()
+	}
+	return f.validate()
+}
 
-	for _, field := range f {
+func (f *Field) validate() error {
+	if err := f.validateObjectType(); err != nil {
+		return err
+	}
+	if err := f.validateTypeSettings(); err != nil {
+		return err
+	}
+	if err := f.validateAnalyzers(); err != nil {
+		return err
+	}
+	if err := f.validateScoring(); err != nil {
+		return err
+	}
+	if err := f.validateTimeSeries(); err != nil {
+		return err
+	}
+	if err := f.Fields.validateMultiFieldKeys(); err != nil {
+		return err
+	}
+	if err := f.validateOverrides(); err != nil {
+		return err
+	}
+	f.warnIgnoredSettings()
+	return nil
+}
 
-		if field.Name == key {
+// validateObjectType ensures objectTypeParams are not mixed with top level
+// objectType configuration and that the scaling factor and match mapping type
+// are valid.
+func (f *Field) validateObjectType() error {
+	if len(f.ObjectTypeParams) != 0 {
+		if f.ScalingFactor != 0 || f.ObjectTypeMappingType != "" || f.ObjectType != "" {
+			return errors.New("mixing top level objectType configuration with array of object type configurations is forbidden")
+		}
+	}
+	if err := validateMatchMappingType(f.ObjectTypeMappingType); err != nil {
+		return err
+	}
+	return validateScalingFactor(f.ScalingFactor)
+}
 
-			//// It's the last key to compare
-			if len(keys) == 0 {
-				return true
-			}
+// validateTypeSettings ensures type specific settings, like scaling_factor,
+// ignore_above, boost, coerce, ignore_malformed and date_format, are only set
+// for the types supporting them, that alias fields have a path and that
+// aggregatable fields have doc_values enabled.
+func (f *Field) validateTypeSettings() error {
+	if f.ScalingFactor != 0 && f.Type != "scaled_float" && f.ObjectType != "scaled_float" {
+		return errors.Errorf("scaling_factor is set for field '%s' but it is not of type scaled_float", f.Name)
+	}
+	if f.IgnoreAbove != 0 && f.Type != "" && f.Type != "keyword" {
+		return errors.Errorf("ignore_above is set for field '%s' but it is not of type keyword", f.Name)
+	}
+	if f.Boost != nil {
+		if f.Type != "" && f.Type != "keyword" && f.Type != "text" {
+			return errors.Errorf("boost is set for field '%s' but it is not of type keyword or text", f.Name)
+		}
+		if *f.Boost < 0 {
+			return errors.Errorf("boost of field '%s' must not be negative", f.Name)
+		}
+	}
+	if f.Coerce != nil && !containsString(numericTypes, f.Type) {
+		return errors.Errorf("coerce is set for field '%s' but it is not of a numeric type", f.Name)
+	}
+	if f.IgnoreMalformed != nil && !containsString(ignoreMalformedTypes, f.Type) {
+		return errors.Errorf("ignore_malformed is set for field '%s' but it is not supported by its type", f.Name)
+	}
+	if f.Type == "alias" && f.AliasPath == "" {
+		return errors.Errorf("alias field '%s' has no path", f.Name)
+	}
+	if f.DateFormat != "" && f.Type != "date" {
+		return errors.Errorf("date_format is set for field '%s' but it is not of type date", f.Name)
+	}
+	if f.DocValues != nil && !*f.DocValues && f.Aggregatable != nil && *f.Aggregatable {
+		return errors.Errorf("field '%s' is aggregatable but doc_values are disabled", f.Name)
+	}
+	return nil
+}
 
-			// It's the last field to compare
-			if len(field.Fields) == 0 {
-				return true
+// warnIgnoredSettings logs a warning for settings and child fields that are
+// valid but have no effect on the mapping.
+func (f *Field) warnIgnoredSettings() {
+	if f.Type != "alias" && f.AliasPath != "" {
+		logp.Warn("Field '%s' is not an alias, its path '%s' is ignored", f.Name, f.AliasPath)
+	}
+	if f.disabled() && len(f.Fields) > 0 {
+		logp.Warn("Field '%s' is disabled, its %d child fields are ignored", f.Name, len(f.Fields))
+	}
+	if f.Type == "text" && f.Index != nil && !*f.Index && f.Aggregatable != nil && *f.Aggregatable {
+		logp.Warn("Field '%s' is not indexed, setting it as aggregatable has no effect", f.Name)
+	}
+	if f.Type == "geo_point" {
+		for _, child := range f.Fields {
+			if child.Name != "lat" && child.Name != "lon" {
+				logp.Warn("Field '%s' is a geo_point, its child field '%s' is ignored", f.Name, child.Name)
 			}
-
-			return field.Fields.hasNode(keys)
 		}
 	}
-	return false
 }
 
-// Recursively generates the correct key based on the dots
-// The mapping requires "properties" between each layer. This is added here.
-func GenerateKey(key string) string {
-	if strings.Contains(key, ".") {
-		keys := strings.SplitN(key, ".", 2)
-		key = keys[0] + ".properties." + GenerateKey(keys[1])
+// validateAnalyzers ensures analyzers are only set for text fields, are not
+// blank and that a search analyzer is only used together with an analyzer, as
+// required by Elasticsearch.
+func (f *Field) validateAnalyzers() error {
+	if f.Analyzer == "" && f.SearchAnalyzer == "" {
+		return nil
 	}
-	return key
+	if f.Type != "text" {
+		return errors.Errorf("analyzer is set for field '%s' but it is not of type text", f.Name)
+	}
+	if f.Analyzer != "" && strings.TrimSpace(f.Analyzer) == "" {
+		return errors.Errorf("analyzer of field '%s' is blank", f.Name)
+	}
+	if f.SearchAnalyzer != "" && strings.TrimSpace(f.SearchAnalyzer) == "" {
+		return errors.Errorf("search_analyzer of field '%s' is blank", f.Name)
+	}
+	if f.SearchAnalyzer != "" && f.Analyzer == "" {
+		return errors.Errorf("search_analyzer is set for field '%s' without an analyzer", f.Name)
+	}
+	return nil
 }
 
-func (f Fields) hasKey(keys []string) bool {
-	// Nothing to compare anymore
-	if len(keys) == 0 {
-		return false
+// numericTypes are the field types holding numbers.
+var numericTypes = []string{"long", "integer", "short", "byte", "double", "float", "half_float", "scaled_float"}
+
+// ignoreMalformedTypes are the field types supporting ignore_malformed.
+var ignoreMalformedTypes = append([]string{"date", "ip", "geo_point", "geo_shape"}, numericTypes...)
+
+// similarities are the built-in similarities of Elasticsearch.
+var similarities = []string{"BM25", "boolean"}
+
+// termVectors are the accepted values of the term_vector setting.
+var termVectors = []string{"no", "yes", "with_positions", "with_offsets", "with_positions_offsets",
+	"with_positions_payloads", "with_positions_offsets_payloads"}
+
+// validateScoring ensures similarity and term_vector are only set for text
+// fields and have known values.
+func (f *Field) validateScoring() error {
+	if f.Similarity == "" && f.TermVector == "" {
+		return nil
+	}
+	if f.Type != "text" {
+		return errors.Errorf("similarity or term_vector is set for field '%s' but it is not of type text", f.Name)
+	}
+	if f.Similarity != "" && !containsString(similarities, f.Similarity) {
+		return errors.Errorf("invalid similarity '%s' for field '%s', expected one of: %s",
+			f.Similarity, f.Name, strings.Join(similarities, ", "))
 	}
+	if f.TermVector != "" && !containsString(termVectors, f.TermVector) {
+		return errors.Errorf("invalid term_vector '%s' for field '%s', expected one of: %s",
+			f.TermVector, f.Name, strings.Join(termVectors, ", "))
+	}
+	return nil
+}
 
-	key := keys[0]
-	keys = keys[1:]
+// timeSeriesMetrics are the accepted values of the time_series_metric setting.
+var timeSeriesMetrics = []string{"gauge", "counter"}
 
-	for _, field := range f {
-		if field.Name == key {
+// validateTimeSeries ensures time_series_metric is only set for numeric fields
+// with a known metric type and time_series_dimension only for keyword fields.
+func (f *Field) validateTimeSeries() error {
+	if f.TimeSeriesMetric != "" {
+		if !containsString(numericTypes, f.Type) {
+			return errors.Errorf("time_series_metric is set for field '%s' but it is not of a numeric type", f.Name)
+		}
+		if !containsString(timeSeriesMetrics, f.TimeSeriesMetric) {
+			return errors.Errorf("invalid time_series_metric '%s' for field '%s', expected one of: %s",
+				f.TimeSeriesMetric, f.Name, strings.Join(timeSeriesMetrics, ", "))
+		}
+	}
+	if f.TimeSeriesDimension != nil && f.Type != "" && f.Type != "keyword" {
+		return errors.Errorf("time_series_dimension is set for field '%s' but it is not of type keyword", f.Name)
+	}
+	return nil
+}
 
-			if len(field.Fields) > 0 {
-				return field.Fields.hasKey(keys)
-			}
-			// Last entry in the tree but still more keys
-			if len(keys) > 0 {
-				return false
-			}
+// isLeaf returns true if the field is mapped as a single field. Fields
+// nested under a disabled group or under a geo_point, like its lat and lon,
+// are not mapped on their own.
+func (f Field) isLeaf() bool {
+	return len(f.Fields) == 0 || f.disabled() || f.Type == "geo_point"
+}
 
-			return true
+// disabled returns true if indexing of the field and its children is disabled
+// with `enabled: false`.
+func (f Field) disabled() bool {
+	return f.Enabled != nil && !*f.Enabled
+}
+
+// ForVersion returns the field with the attributes set in its overrides for
+// the given Elasticsearch major version applied. The name, child fields and
+// multi-fields of the field can not be overridden.
+func (f Field) ForVersion(major int) Field {
+	override, found := f.Overrides["v"+strconv.Itoa(major)]
+	if !found {
+		return f
+	}
+
+	rv := reflect.ValueOf(&f).Elem()
+	ov := reflect.ValueOf(override)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		switch configName(rt.Field(i)) {
+		case "", "name", "fields", "multi_fields", "overrides":
+			continue
+		}
+		if value := ov.Field(i); !isUnset(value) {
+			rv.Field(i).Set(value)
 		}
 	}
-	return false
+	return f
 }
 
-// GetKeys returns a flat list of keys this Fields contains
-func (f Fields) GetKeys() []string {
-	return f.getKeys("")
+// typeVersions holds the Elasticsearch major versions supporting field types
+// that are not available in all versions. A limit of 0 means no limit.
+var typeVersions = map[string]struct{ min, max int }{
+	"string":       {max: 2},
+	"keyword":      {min: 5},
+	"text":         {min: 5},
+	"half_float":   {min: 5},
+	"scaled_float": {min: 5},
+	"alias":        {min: 6},
 }
 
-func (f Fields) getKeys(namespace string) []string {
+// validateOverrides ensures overrides are keyed by major version, like `v7`, and only
+// use types available in that version.
+func (f *Field) validateOverrides() error {
+	versions := make([]string, 0, len(f.Overrides))
+	for version := range f.Overrides {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
 
-	var keys []string
+	for _, version := range versions {
+		major, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+		if err != nil || major <= 0 || !strings.HasPrefix(version, "v") {
+			return errors.Errorf("invalid version '%s' in overrides of field '%s', expected a major version like 'v7'", version, f.Name)
+		}
+		fieldType := f.Overrides[version].Type
+		if limits, found := typeVersions[fieldType]; found && (major < limits.min || (limits.max != 0 && major > limits.max)) {
+			return errors.Errorf("type '%s' in overrides of field '%s' is not available in version %d", fieldType, f.Name, major)
+		}
+	}
+	return nil
+}
+
+// Validate validates all fields of the tree, including groups and
+// multi-fields. All errors found are returned, prefixed with the dotted key of
+// the field.
+func (f Fields) Validate() error {
+	var errs multierror.Errors
+	f.validate("", fieldPath{}, &errs)
+	f.validateCopyTo(&errs)
+	f.validateDuplicateKeys(&errs)
+	if err := f.validateMultiFieldKeys(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.Err()
+}
 
+// validateMultiFieldKeys ensures the keys of the multi-fields of the fields,
+// like `message.keyword`, are not used by sibling fields with a dotted name.
+func (f Fields) validateMultiFieldKeys() error {
+	names := map[string]bool{}
 	for _, field := range f {
+		names[field.Name] = true
+	}
+	for _, field := range f {
+		for _, multiField := range field.MultiFields {
+			if key := field.Name + "." + multiField.Name; names[key] {
+				return errors.Errorf("multi-field '%s' of field '%s' collides with field '%s'", multiField.Name, field.Name, key)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDuplicateKeys ensures leaf keys defined multiple times, like in
+// groups with the same name declared in different files, have the same
+// definition. Identical definitions are allowed, only one of them is mapped.
+// Fields without a type are compared as keyword.
+func (f Fields) validateDuplicateKeys(errs *multierror.Errors) {
+	first := map[string]Field{}
+	f.walkLeaves("", func(key string, field Field) {
+		if field.Type == "" {
+			field.Type = "keyword"
+		}
+		existing, found := first[key]
+		if !found {
+			first[key] = field
+			return
+		}
+		for _, change := range existing.changes(key, field) {
+			*errs = append(*errs, errors.Errorf("field '%s' is defined multiple times with conflicting %s: '%v' and '%v'",
+				key, change.Attribute, change.Old, change.New))
+		}
+	})
+}
+
+// validateCopyTo ensures the copy_to targets of all leaf fields are other leaf
+// fields of the tree.
+func (f Fields) validateCopyTo(errs *multierror.Errors) {
+	f.walkLeaves("", func(key string, field Field) {
+		for _, target := range field.CopyTo {
+			if target == key {
+				*errs = append(*errs, errors.Wrap(errors.New("copy_to target is the field itself"), key))
+			} else if !f.HasKey(target) {
+				*errs = append(*errs, errors.Wrap(errors.Errorf("copy_to target '%s' does not exist", target), key))
+			}
+		}
+	})
+}
+
+// CopyToTargets returns for every leaf field with copy_to set the list of its
+// targets.
+func (f Fields) CopyToTargets() map[string][]string {
+	targets := map[string][]string{}
+	f.walkLeaves("", func(key string, field Field) {
+		if len(field.CopyTo) > 0 {
+			targets[key] = append(targets[key], field.CopyTo...)
+		}
+	})
+	return targets
+}
+
+// AnalyzersUsed returns the sorted names of the analyzers and search analyzers
+// referenced by the fields, including multi-fields. They need to be defined
+// in the index settings for the mappings to be accepted.
+func (f Fields) AnalyzersUsed() []string {
+	seen := map[string]bool{}
+	f.walkLeaves("", func(key string, field Field) {
+		for _, analyzer := range []string{field.Analyzer, field.SearchAnalyzer} {
+			if analyzer != "" {
+				seen[analyzer] = true
+			}
+		}
+	})
+
+	var analyzers []string
+	for analyzer := range seen {
+		analyzers = append(analyzers, analyzer)
+	}
+	sort.Strings(analyzers)
+	return analyzers
+}
+
+func (f Fields) validate(namespace string, path fieldPath, errs *multierror.Errors) {
+	for i := range f {
+		field := &f[i]
 		fieldName := namespace + "." + field.Name
 		if namespace == "" {
 			fieldName = field.Name
 		}
-		if len(field.Fields) == 0 {
-			keys = append(keys, fieldName)
-		} else {
-			keys = append(keys, field.Fields.getKeys(fieldName)...)
+		if !path.enter(field) {
+			*errs = append(*errs, errors.Errorf("%s: field is nested in itself", fieldName))
+			continue
 		}
+		if err := field.Validate(); err != nil {
+			*errs = append(*errs, errors.Wrap(err, fieldName))
+		}
+		field.Fields.validate(fieldName, path, errs)
+		field.MultiFields.validate(fieldName, path, errs)
+		path.leave(field)
 	}
+}
 
-	return keys
+// LoadFieldsYaml loads the fields from the fields.yml file at the given path.
+func LoadFieldsYaml(path string, opts ...LoadOption) (Fields, error) {
+	cfg, err := yaml.NewConfigWithFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return loadFields(cfg, newLoadOptions(opts))
+}
+
+// LoadOption configures how LoadFieldsYaml and LoadFields load fields.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	normalizeDeprecatedTypes bool
+	rejectDeprecatedTypes    bool
+}
+
+func newLoadOptions(opts []LoadOption) loadOptions {
+	var options loadOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// NormalizeDeprecatedTypes replaces deprecated types, like `string`, by their
+// current equivalent when loading fields, logging a deprecation warning. By
+// default deprecated types are kept as they are.
+func NormalizeDeprecatedTypes() LoadOption {
+	return func(o *loadOptions) {
+		o.normalizeDeprecatedTypes = true
+	}
+}
+
+// RejectDeprecatedTypes makes loading fail on fields using a deprecated type,
+// like `string`. It takes precedence over NormalizeDeprecatedTypes.
+func RejectDeprecatedTypes() LoadOption {
+	return func(o *loadOptions) {
+		o.rejectDeprecatedTypes = true
+	}
+}
+
+// LoadFields loads the fields from a fields.yml document read from r, like an
+// embedded or downloaded schema. Unlike LoadFieldsYaml it also validates the
+// loaded tree with Fields.Validate.
+func LoadFields(r io.Reader, opts ...LoadOption) (Fields, error) {
+	options := newLoadOptions(opts)
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading fields")
+	}
+
+	cfg, err := yaml.NewConfig(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing fields")
+	}
+
+	fields, err := loadFields(cfg, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := fields.Validate(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// loadFields unpacks the fields of all keys of a fields.yml document.
+func loadFields(cfg *ucfg.Config, options loadOptions) (Fields, error) {
+	var keys []Field
+	if err := cfg.Unpack(&keys); err != nil {
+		return nil, errors.Wrap(err, "unpacking fields")
+	}
+
+	fields := Fields{}
+	for _, key := range keys {
+		fields = append(fields, key.Fields...)
+	}
+
+	var errs multierror.Errors
+	fields.normalizeTypes("", options, &errs)
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// LoadFieldsFromGlob loads the fields of all files matching the glob pattern,
+// in lexical order, and merges them into one tree. Merge conflicts are
+// reported with the file defining the conflicting field and, if known, the
+// file it was previously defined in.
+func LoadFieldsFromGlob(pattern string) (Fields, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("no fields files matching '%s'", pattern)
+	}
+
+	var fields Fields
+	origins := map[string]string{}
+	for _, file := range files {
+		loaded, err := LoadFieldsYaml(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading fields from %s", file)
+		}
+
+		fields, err = fields.Merge(loaded)
+		if err != nil {
+			if conflict, ok := err.(*fieldConflictError); ok {
+				if origin, found := origins[conflict.key]; found {
+					return nil, errors.Errorf("%s: %v (previously defined in %s)", file, err, origin)
+				}
+			}
+			return nil, errors.Wrap(err, file)
+		}
+
+		for _, key := range loaded.GetKeysWithGroups() {
+			if _, found := origins[key]; !found {
+				origins[key] = file
+			}
+		}
+	}
+	return fields, nil
+}
+
+// HasKey checks if inside fields the given key exists
+// The key can be in the form of a.b.c and it will check if the nested field exist
+// In case the key is `a` and there is a value `a.b` false is return as it only
+// returns true if it's a leave node. Multi-fields of a leaf, like
+// `message.keyword`, are also found.
+func (f Fields) HasKey(key string) bool {
+	return f.hasKey(key)
+}
+
+// HasKeyFold is a lenient variant of HasKey, comparing every segment of the
+// key case-insensitively, so `Test.Find` matches the field `test.find`. It is
+// meant for checking user-provided field references, Elasticsearch field names
+// are case-sensitive, so use HasKey when the exact key matters.
+func (f Fields) HasKeyFold(key string) bool {
+	name, rest, more := key, "", false
+	if idx := strings.IndexByte(key, '.'); idx >= 0 {
+		name, rest, more = key[:idx], key[idx+1:], true
+	}
+
+	// Several fields can match when names only differ in case, try all of them.
+	for i := range f {
+		field := &f[i]
+		if !strings.EqualFold(field.Name, name) {
+			continue
+		}
+		var found bool
+		switch {
+		case !field.isLeaf():
+			found = more && field.Fields.HasKeyFold(rest)
+		case more:
+			found = field.MultiFields.HasKeyFold(rest)
+		default:
+			found = true
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldIndex is a lookup table of the leaf fields of a tree, built once by
+// Fields.BuildIndex for repeated lookups.
+type FieldIndex struct {
+	fields map[string]Field
+}
+
+// BuildIndex indexes all leaf fields of the tree by their dotted keys,
+// including multi-fields and aliases, as visited by the tree walk. If a key is
+// defined multiple times, the first definition is indexed.
+func (f Fields) BuildIndex() FieldIndex {
+	index := FieldIndex{fields: map[string]Field{}}
+	f.walkLeaves("", func(key string, field Field) {
+		if _, exists := index.fields[key]; !exists {
+			index.fields[key] = field
+		}
+	})
+	return index
+}
+
+// Has returns true if the key is a leaf field of the indexed tree.
+func (i FieldIndex) Has(key string) bool {
+	_, found := i.fields[key]
+	return found
+}
+
+// Get returns the leaf field with the given key. Aliases are returned as is,
+// use Fields.ResolveAlias to get the field they point to.
+func (i FieldIndex) Get(key string) (Field, bool) {
+	field, found := i.fields[key]
+	return field, found
+}
+
+// Len returns the number of indexed keys.
+func (i FieldIndex) Len() int {
+	return len(i.fields)
+}
+
+// HasNode checks if inside fields the given node exists
+// In contrast to HasKey it not only compares the leaf nodes but
+// every single key it traverses.
+func (f Fields) HasNode(key string) bool {
+	keys := strings.Split(key, ".")
+	return f.hasNode(keys)
+}
+
+func (f Fields) hasNode(keys []string) bool {
+
+	// Nothing to compare, so does not contain it
+	if len(keys) == 0 {
+		return false
+	}
+
+	key := keys[0]
+	keys = keys[1:]
+
+	for _, field := range f {
+
+		if field.Name == key {
+
+			//// It's the last key to compare
+			if len(keys) == 0 {
+				return true
+			}
+
+			// It's the last field to compare
+			if len(field.Fields) == 0 {
+				return true
+			}
+
+			return field.Fields.hasNode(keys)
+		}
+	}
+	return false
+}
+
+// Recursively generates the correct key based on the dots
+// The mapping requires "properties" between each layer. This is added here.
+func GenerateKey(key string) string {
+	if strings.Contains(key, ".") {
+		keys := strings.SplitN(key, ".", 2)
+		key = keys[0] + ".properties." + GenerateKey(keys[1])
+	}
+	return key
+}
+
+// hasKey walks the key segment by segment without splitting it, to not
+// allocate on lookups.
+func (f Fields) hasKey(key string) bool {
+	name, rest, more := key, "", false
+	if idx := strings.IndexByte(key, '.'); idx >= 0 {
+		name, rest, more = key[:idx], key[idx+1:], true
+	}
+
+	for i := range f {
+		field := &f[i]
+		if field.Name == name {
+			if !field.isLeaf() {
+				// Nothing to compare anymore
+				return more && field.Fields.hasKey(rest)
+			}
+			// Last entry in the tree but still more keys
+			if more {
+				return field.MultiFields.hasKey(rest)
+			}
+
+			return true
+		}
+	}
+	return false
+}
+
+// GetKeys returns a flat list of keys this Fields contains. The keys of
+// multi-fields are listed after the key of the field declaring them. Groups
+// disabled with `enabled: false` and geo_point fields are listed as a single
+// key, without their children. Fields nested in themselves are skipped without
+// reporting an error, use Validate to detect them.
+func (f Fields) GetKeys() []string {
+	return f.getKeys("", fieldPath{})
+}
+
+// Keys calls yield with every key GetKeys returns, in the same order, without
+// building the list of keys. Iteration stops when yield returns false. As in
+// GetKeys, fields nested in themselves are skipped silently.
+func (f Fields) Keys(yield func(string) bool) {
+	var b keyBuilder
+	f.visitKeys(&b, fieldPath{}, func(name string) bool {
+		return yield(string(b.prefix) + name)
+	})
+}
+
+// keyBuilder collects dotted keys in a single buffer, so all keys returned
+// share one allocation instead of allocating a string per key and group.
+type keyBuilder struct {
+	prefix []byte
+	buf    []byte
+	ends   []int
+}
+
+func (b *keyBuilder) add(name string) {
+	b.buf = append(b.buf, b.prefix...)
+	b.buf = append(b.buf, name...)
+	b.ends = append(b.ends, len(b.buf))
+}
+
+// push adds name to the prefix of the following keys and returns the length of
+// the prefix to restore with pop.
+func (b *keyBuilder) push(name string) int {
+	n := len(b.prefix)
+	b.prefix = append(b.prefix, name...)
+	b.prefix = append(b.prefix, '.')
+	return n
+}
+
+func (b *keyBuilder) pop(n int) {
+	b.prefix = b.prefix[:n]
+}
+
+func (b *keyBuilder) keys() []string {
+	if len(b.ends) == 0 {
+		return nil
+	}
+	all := string(b.buf)
+	keys := make([]string, len(b.ends))
+	start := 0
+	for i, end := range b.ends {
+		keys[i] = all[start:end]
+		start = end
+	}
+	return keys
+}
+
+// visitKeys walks the tree in the order of GetKeys, calling visit with the
+// name of every leaf while the prefix of b holds the key of its parent. It
+// returns false as soon as visit does.
+func (f Fields) visitKeys(b *keyBuilder, path fieldPath, visit func(name string) bool) bool {
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		more := true
+		if field.isLeaf() {
+			more = visit(field.Name)
+			if more && len(field.MultiFields) > 0 {
+				n := b.push(field.Name)
+				more = field.MultiFields.visitKeys(b, path, visit)
+				b.pop(n)
+			}
+		} else {
+			n := b.push(field.Name)
+			more = field.Fields.visitKeys(b, path, visit)
+			b.pop(n)
+		}
+		path.leave(field)
+		if !more {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Fields) getKeys(namespace string, path fieldPath) []string {
+	var b keyBuilder
+	if namespace != "" {
+		b.push(namespace)
+	}
+	f.visitKeys(&b, path, func(name string) bool {
+		b.add(name)
+		return true
+	})
+	return b.keys()
+}
+
+// GetKeysWithGroups returns a flat list of keys this Fields contains,
+// including the keys of the intermediate groups. Keys are listed depth-first
+// in declaration order, every key is only listed once.
+func (f Fields) GetKeysWithGroups() []string {
+	return f.getKeysWithGroups("", nil, map[string]struct{}{}, fieldPath{})
+}
+
+func (f Fields) getKeysWithGroups(namespace string, keys []string, seen map[string]struct{}, path fieldPath) []string {
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+		if _, exists := seen[fieldName]; !exists {
+			seen[fieldName] = struct{}{}
+			keys = append(keys, fieldName)
+		}
+		keys = field.Fields.getKeysWithGroups(fieldName, keys, seen, path)
+		keys = field.MultiFields.getKeysWithGroups(fieldName, keys, seen, path)
+		path.leave(field)
+	}
+
+	return keys
+}
+
+// FieldsDiff holds the differences between two Fields trees, keyed by the
+// dotted leaf keys as returned by GetKeys.
+type FieldsDiff struct {
+	// Removed contains the keys only present in the receiver.
+	Removed []string
+	// Added contains the keys only present in the other Fields.
+	Added []string
+	// Changed contains an entry per attribute that differs for keys present
+	// in both Fields.
+	Changed []FieldChange
+}
+
+// FieldChange describes a single attribute that differs between two
+// definitions of the same key.
+type FieldChange struct {
+	Key       string
+	Attribute string
+	Old       interface{}
+	New       interface{}
+}
+
+// Diff compares the leaf keys of f with the ones of other. A field changing
+// from a leaf to a group is reported as the leaf key being removed and the
+// keys of the group being added.
+// If a key is defined multiple times, the first definition is used.
+func (f Fields) Diff(other Fields) FieldsDiff {
+	var diff FieldsDiff
+
+	oldKeys, oldFields := f.getLeaves()
+	newKeys, newFields := other.getLeaves()
+
+	for _, key := range oldKeys {
+		newField, found := newFields[key]
+		if !found {
+			diff.Removed = append(diff.Removed, key)
+			continue
+		}
+		diff.Changed = append(diff.Changed, oldFields[key].changes(key, newField)...)
+	}
+
+	for _, key := range newKeys {
+		if _, found := oldFields[key]; !found {
+			diff.Added = append(diff.Added, key)
+		}
+	}
+
+	return diff
+}
+
+// changes returns the attributes compared by Diff which differ between f and other.
+func (f Field) changes(key string, other Field) []FieldChange {
+	var changes []FieldChange
+
+	add := func(attr string, old, new interface{}) {
+		if old != new {
+			changes = append(changes, FieldChange{Key: key, Attribute: attr, Old: old, New: new})
+		}
+	}
+	add("type", f.Type, other.Type)
+	add("object_type", f.ObjectType, other.ObjectType)
+	add("scaling_factor", f.ScalingFactor, other.ScalingFactor)
+	add("dynamic", f.Dynamic.Value, other.Dynamic.Value)
+
+	return changes
+}
+
+// getLeaves collects the leaf fields in the same order as getKeys. Each key
+// is only reported once, with the first definition found.
+func (f Fields) getLeaves() ([]string, map[string]Field) {
+	var keys []string
+	leaves := map[string]Field{}
+	f.walkLeaves("", func(key string, field Field) {
+		if _, exists := leaves[key]; !exists {
+			keys = append(keys, key)
+			leaves[key] = field
+		}
+	})
+	return keys, leaves
+}
+
+// Merge deep merges other into a copy of f. Fields with the same name are
+// merged recursively. An error is returned if two fields with the same dotted
+// key disagree on their type or object type, or if a key is defined as both
+// a leaf and a group.
+func (f Fields) Merge(other Fields) (Fields, error) {
+	return f.merge(other, "", fieldPath{})
+}
+
+func (f Fields) merge(other Fields, namespace string, path fieldPath) (Fields, error) {
+	merged := make(Fields, len(f))
+	copy(merged, f)
+
+	for i := range other {
+		field := other[i]
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+
+		idx := merged.indexOf(field.Name)
+		if idx < 0 {
+			merged = append(merged, field)
+			continue
+		}
+
+		if !path.enter(&other[i]) {
+			return nil, newConflictError(fieldName, "field '%s' is nested in itself", fieldName)
+		}
+
+		existing := merged[idx]
+		if existing.Type != field.Type {
+			return nil, newConflictError(fieldName, "field '%s' has conflicting types: '%s' and '%s'", fieldName, existing.Type, field.Type)
+		}
+		if existing.ObjectType != field.ObjectType {
+			return nil, newConflictError(fieldName, "field '%s' has conflicting object types: '%s' and '%s'", fieldName, existing.ObjectType, field.ObjectType)
+		}
+		if (len(existing.Fields) == 0) != (len(field.Fields) == 0) {
+			return nil, newConflictError(fieldName, "field '%s' is defined as both a leaf and a group", fieldName)
+		}
+
+		if len(field.Fields) > 0 {
+			var err error
+			existing.Fields, err = existing.Fields.merge(field.Fields, fieldName, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		merged[idx] = existing
+		path.leave(&other[i])
+	}
+
+	return merged, nil
+}
+
+// fieldConflictError is returned by Merge for conflicting definitions of the
+// field with the given dotted key.
+type fieldConflictError struct {
+	key string
+	msg string
+}
+
+func newConflictError(key, format string, args ...interface{}) error {
+	return &fieldConflictError{key: key, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *fieldConflictError) Error() string {
+	return e.msg
+}
+
+// indexOf returns the index of the first field with the given name, or -1 if
+// there is none.
+func (f Fields) indexOf(name string) int {
+	for i, field := range f {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ConflictingKeys returns for every leaf key defined multiple times with
+// differing types the list of distinct types found, in order of appearance.
+// Fields without a type are reported as keyword, the type they get mapped
+// to. Alias fields are compared with the type of the field they point to.
+func (f Fields) ConflictingKeys() map[string][]string {
+	types := map[string][]string{}
+	var keys []string
+	f.walkLeaves("", func(key string, field Field) {
+		fieldType := f.effectiveType(field)
+		if _, exists := types[key]; !exists {
+			keys = append(keys, key)
+		}
+		for _, t := range types[key] {
+			if t == fieldType {
+				return
+			}
+		}
+		types[key] = append(types[key], fieldType)
+	})
+
+	conflicts := map[string][]string{}
+	for _, key := range keys {
+		if len(types[key]) > 1 {
+			conflicts[key] = types[key]
+		}
+	}
+	return conflicts
+}
+
+// DetectLeafGroupConflicts returns the sorted dotted keys that are defined both
+// as a leaf field and as a group of other fields, which Elasticsearch can not
+// map. Dots in field names are handled as nesting, so `process.name` makes
+// `process` a group. Object fields and disabled groups can hold other fields
+// and are not reported.
+func (f Fields) DetectLeafGroupConflicts() []string {
+	leaves := map[string]bool{}
+	groups := map[string]bool{}
+	f.walkStructure("", fieldPath{}, func(key string, _ Field, leaf bool) {
+		if leaf {
+			leaves[key] = true
+		}
+		for i := strings.LastIndexByte(key, '.'); i > 0; i = strings.LastIndexByte(key[:i], '.') {
+			groups[key[:i]] = true
+		}
+	})
+
+	var conflicts []string
+	for key := range leaves {
+		if groups[key] {
+			conflicts = append(conflicts, key)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// ValidateDescriptions returns the dotted keys of the fields without child
+// fields, in declaration order, whose description is empty or shorter than minLen
+// characters, ignoring surrounding whitespace. Multi-fields and aliases, which
+// are documented by the fields they belong or point to, are not checked.
+func (f Fields) ValidateDescriptions(minLen int) []string {
+	var keys []string
+	f.walkStructure("", fieldPath{}, func(key string, field Field, _ bool) {
+		if !field.isLeaf() || field.Type == "alias" {
+			return
+		}
+		description := strings.TrimSpace(field.Description)
+		if description == "" || utf8.RuneCountInString(description) < minLen {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// walkStructure calls fn for every field of the tree, excluding multi-fields,
+// reporting whether it is mapped as a leaf value or as an object that can hold
+// other fields.
+func (f Fields) walkStructure(namespace string, path fieldPath, fn func(key string, field Field, leaf bool)) {
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+		switch {
+		case field.disabled():
+			fn(fieldName, *field, false)
+		case field.isLeaf() && field.Type != "group" && field.Type != "object" && field.Type != "nested":
+			fn(fieldName, *field, true)
+		default:
+			fn(fieldName, *field, false)
+			field.Fields.walkStructure(fieldName, path, fn)
+		}
+		path.leave(field)
+	}
+}
+
+// Contains checks that the tree is a superset of other, so that every leaf key
+// of other, including multi-fields, is also defined in this tree with the same
+// type. Types are compared as mapped, so fields without a type match keyword
+// fields and aliases match any field of the type they point to. The keys
+// missing or incompatible are returned in the order they are defined in other.
+func (f Fields) Contains(other Fields) (bool, []string) {
+	index := f.BuildIndex()
+	var keys []string
+	seen := map[string]bool{}
+	other.walkLeaves("", func(key string, field Field) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		if existing, found := index.Get(key); !found || f.effectiveType(existing) != other.effectiveType(field) {
+			keys = append(keys, key)
+		}
+	})
+	return len(keys) == 0, keys
+}
+
+// effectiveType returns the type a field is mapped to. Aliases are followed
+// to their target. Fields without a type are mapped to keyword, unless they
+// have child fields, like disabled groups, which are mapped to object.
+func (f Fields) effectiveType(field Field) string {
+	switch field.Type {
+	case "":
+		if len(field.Fields) > 0 {
+			return "object"
+		}
+		return "keyword"
+	case "alias":
+		key, err := f.ResolveAlias(field.AliasPath)
+		if err != nil {
+			return field.Type
+		}
+		target, _ := f.getLeaf(key)
+		return f.effectiveType(target)
+	default:
+		return field.Type
+	}
+}
+
+// ResolveAlias follows the path of alias fields, starting at the given key,
+// until a field that is not an alias is found and returns its key. If the key
+// is not an alias it is returned as is. An error is returned if a key in the
+// chain does not exist or if the aliases form a cycle.
+func (f Fields) ResolveAlias(key string) (string, error) {
+	visited := map[string]bool{}
+	for {
+		field, found := f.getLeaf(key)
+		if !found {
+			return "", errors.Errorf("field '%s' not found", key)
+		}
+		if field.Type != "alias" {
+			return key, nil
+		}
+		visited[key] = true
+		if visited[field.AliasPath] {
+			return "", errors.Errorf("alias '%s' is part of a cycle", key)
+		}
+		key = field.AliasPath
+	}
+}
+
+// ValidateAliases checks that the path of every alias field resolves to a
+// leaf field which is not an alias, following chains of aliases. An error is
+// returned for every alias that can not be resolved, with the dotted key of
+// the alias and its target.
+func (f Fields) ValidateAliases() error {
+	var errs multierror.Errors
+	f.walkLeaves("", func(key string, field Field) {
+		if field.Type != "alias" {
+			return
+		}
+		if _, err := f.ResolveAlias(key); err != nil {
+			errs = append(errs, errors.Wrapf(err, "alias '%s' with target '%s' can not be resolved", key, field.AliasPath))
+		}
+	})
+	return errs.Err()
+}
+
+// getLeaf returns the first leaf field definition found for the given key.
+func (f Fields) getLeaf(key string) (Field, bool) {
+	var (
+		leaf  Field
+		found bool
+	)
+	f.walkLeaves("", func(k string, field Field) {
+		if !found && k == key {
+			leaf, found = field, true
+		}
+	})
+	return leaf, found
+}
+
+// walkLeaves calls fn for every leaf field, in the same order as getKeys.
+// Fields nested in themselves are skipped.
+func (f Fields) walkLeaves(namespace string, fn func(key string, field Field)) {
+	f.walkLeavesPath(namespace, fieldPath{}, fn)
+}
+
+func (f Fields) walkLeavesPath(namespace string, path fieldPath, fn func(key string, field Field)) {
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+		if field.isLeaf() {
+			fn(fieldName, *field)
+			field.MultiFields.walkLeavesPath(fieldName, path, fn)
+		} else {
+			field.Fields.walkLeavesPath(fieldName, path, fn)
+		}
+		path.leave(field)
+	}
+}
+
+// FilterByType returns a copy of the tree only containing the leaf fields with
+// one of the given types. As in TypeMap, fields without a type match keyword
+// and aliases match the type of the field they point to, aliases also match
+// alias. Groups left without any fields are removed.
+func (f Fields) FilterByType(types ...string) Fields {
+	set := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		set[t] = struct{}{}
+	}
+	return f.filter("", fieldPath{}, func(_ string, field Field) bool {
+		if _, ok := set[field.Type]; ok {
+			return true
+		}
+		_, ok := set[f.effectiveType(field)]
+		return ok
+	})
+}
+
+// Select returns a copy of the tree only containing the leaf fields whose
+// dotted key matches the given glob pattern (e.g. `system.process.*` or
+// `*.ip`), using the syntax of path.Match. A leaf is also kept if the key of
+// one of its multi-fields matches. Invalid patterns don't match any field.
+func (f Fields) Select(pattern string) Fields {
+	match := func(key string) bool {
+		matched, err := path.Match(pattern, key)
+		return err == nil && matched
+	}
+	return f.filter("", fieldPath{}, func(key string, field Field) bool {
+		if match(key) {
+			return true
+		}
+		for _, k := range field.MultiFields.getKeys(key, fieldPath{}) {
+			if match(k) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Intersect returns a copy of the tree only containing the leaf fields whose
+// key is also defined in other and mapped to the same type, as reported by
+// TypeMap. Keys defined in both trees with different types are left out, they
+// are listed in the Changed attributes of Diff.
+func (f Fields) Intersect(other Fields) Fields {
+	types := f.TypeMap()
+	otherTypes := other.TypeMap()
+	return f.filter("", fieldPath{}, func(key string, _ Field) bool {
+		otherType, found := otherTypes[key]
+		return found && types[key] == otherType
+	})
+}
+
+// FilterEnabled returns a copy of the tree without the fields for which
+// enabled returns false, so fields of features that are not used, like the
+// fields of a metricset that is not collected, are not mapped. enabled is
+// called with the dotted key of every group, field and multi-field, the
+// children of a removed group are not visited. Groups left without any fields
+// are removed.
+func (f Fields) FilterEnabled(enabled func(path string) bool) Fields {
+	return f.filterEnabled("", fieldPath{}, enabled)
+}
+
+func (f Fields) filterEnabled(namespace string, path fieldPath, enabled func(path string) bool) Fields {
+	var filtered Fields
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+		if enabled(fieldName) {
+			c := field.cloneAttributes()
+			c.MultiFields = field.MultiFields.filterEnabled(fieldName, path, enabled)
+			if field.isLeaf() {
+				c.Fields = field.Fields.clone(path)
+				filtered = append(filtered, c)
+			} else if c.Fields = field.Fields.filterEnabled(fieldName, path, enabled); len(c.Fields) > 0 {
+				filtered = append(filtered, c)
+			}
+		}
+		path.leave(field)
+	}
+	return filtered
+}
+
+// filter returns a deep copy of the tree only containing the leaf fields
+// matching keep. Groups left without any fields are removed.
+func (f Fields) filter(namespace string, path fieldPath, keep func(key string, field Field) bool) Fields {
+	var filtered Fields
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+		if field.isLeaf() {
+			if keep(fieldName, *field) {
+				c := field.cloneAttributes()
+				c.Fields = field.Fields.clone(path)
+				c.MultiFields = field.MultiFields.clone(path)
+				filtered = append(filtered, c)
+			}
+		} else if children := field.Fields.filter(fieldName, path, keep); len(children) > 0 {
+			c := field.cloneAttributes()
+			c.Fields = children
+			c.MultiFields = field.MultiFields.clone(path)
+			filtered = append(filtered, c)
+		}
+		path.leave(field)
+	}
+	return filtered
+}
+
+// cloneAttributes returns a deep copy of the field, without its nested fields
+// and multi-fields.
+func (f Field) cloneAttributes() Field {
+	c := f
+	c.Fields = nil
+	c.MultiFields = nil
+	c.Enabled = cloneBool(f.Enabled)
+	c.Index = cloneBool(f.Index)
+	c.Norms = cloneBool(f.Norms)
+	c.DefaultField = cloneBool(f.DefaultField)
+	c.DocValues = cloneBool(f.DocValues)
+	c.Coerce = cloneBool(f.Coerce)
+	c.IgnoreMalformed = cloneBool(f.IgnoreMalformed)
+	c.TimeSeriesDimension = cloneBool(f.TimeSeriesDimension)
+	c.Analyzed = cloneBool(f.Analyzed)
+	c.Searchable = cloneBool(f.Searchable)
+	c.Aggregatable = cloneBool(f.Aggregatable)
+	c.OpenLinkInCurrentTab = cloneBool(f.OpenLinkInCurrentTab)
+	if f.Overrides != nil {
+		c.Overrides = make(map[string]Field, len(f.Overrides))
+		for version, override := range f.Overrides {
+			c.Overrides[version] = override.cloneAttributes()
+		}
+	}
+	if f.Boost != nil {
+		boost := *f.Boost
+		c.Boost = &boost
+	}
+	if f.OutputPrecision != nil {
+		precision := *f.OutputPrecision
+		c.OutputPrecision = &precision
+	}
+	if f.ObjectTypeParams != nil {
+		c.ObjectTypeParams = append([]ObjectTypeCfg(nil), f.ObjectTypeParams...)
+	}
+	if f.UrlTemplate != nil {
+		c.UrlTemplate = append([]VersionizedString(nil), f.UrlTemplate...)
+	}
+	if f.CopyTo != nil {
+		c.CopyTo = append([]string(nil), f.CopyTo...)
+	}
+	if f.Meta != nil {
+		c.Meta = f.Meta.Clone()
+	}
+	return c
+}
+
+// Clone returns a deep copy of the tree, including multi-fields and all
+// attributes, so the copy can be modified without affecting the original.
+// Fields nested in themselves are left out.
+func (f Fields) Clone() Fields {
+	return f.clone(fieldPath{})
+}
+
+// clone returns a deep copy of the tree. Fields nested in themselves are
+// left out.
+func (f Fields) clone(path fieldPath) Fields {
+	if f == nil {
+		return nil
+	}
+	c := make(Fields, 0, len(f))
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		cf := field.cloneAttributes()
+		cf.Fields = field.Fields.clone(path)
+		cf.MultiFields = field.MultiFields.clone(path)
+		c = append(c, cf)
+		path.leave(field)
+	}
+	return c
+}
+
+func cloneBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	v := *b
+	return &v
+}
+
+// Canonicalize returns a copy of the tree with the fields on each level sorted
+// by name. Groups with the same name are merged into the first one, identical
+// leaf definitions are only kept once. Conflicting definitions are kept as
+// they are.
+func (f Fields) Canonicalize() Fields {
+	sorted := f.clone(fieldPath{})
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var result Fields
+	// start is the index in result of the first field with the current name
+	start := 0
+	for _, field := range sorted {
+		if len(result) == 0 || result[len(result)-1].Name != field.Name {
+			start = len(result)
+			result = append(result, field)
+			continue
+		}
+		if !result[start:].canonicalMerge(field) {
+			result = append(result, field)
+		}
+	}
+
+	for i := range result {
+		if len(result[i].Fields) > 0 {
+			result[i].Fields = result[i].Fields.Canonicalize()
+		}
+	}
+	return result
+}
+
+// canonicalMerge merges field into one of the fields with the same name, if
+// it is a group, or drops it if an identical leaf exists. It returns false if
+// field must be kept on its own.
+func (f Fields) canonicalMerge(field Field) bool {
+	for i := range f {
+		existing := &f[i]
+		if len(existing.Fields) > 0 && len(field.Fields) > 0 {
+			existing.Fields = append(existing.Fields, field.Fields...)
+			return true
+		}
+		if len(field.Fields) == 0 && reflect.DeepEqual(*existing, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprint returns a hex encoded SHA-256 hash of the canonicalized tree,
+// covering the names and all attributes of the fields except descriptions, so
+// it doesn't depend on the order in which fields are declared. Fields which
+// are Equal have the same fingerprint.
+func (f Fields) Fingerprint() string {
+	hash := sha256.Sum256(f.canonicalYAML())
+	return hex.EncodeToString(hash[:])
+}
+
+// canonicalYAML returns the canonicalized tree without descriptions in the
+// fields.yml format.
+func (f Fields) canonicalYAML() []byte {
+	canonical := f.Canonicalize()
+	canonical.stripDescriptions()
+	canonical.sortSiblings()
+
+	// Errors are not expected, fields only contain serializable values
+	data, _ := yamlv2.Marshal(canonical)
+	return data
+}
+
+// sortSiblings sorts the fields and multi-fields on each level by name. Ties
+// between fields with the same name are broken by their full definition, so
+// conflicting definitions don't keep their declaration order.
+func (f Fields) sortSiblings() {
+	for i := range f {
+		f[i].Fields.sortSiblings()
+		f[i].MultiFields.sortSiblings()
+	}
+	sort.SliceStable(f, func(i, j int) bool {
+		if f[i].Name != f[j].Name {
+			return f[i].Name < f[j].Name
+		}
+		return fieldDefinition(f[i]) < fieldDefinition(f[j])
+	})
+}
+
+func fieldDefinition(field Field) string {
+	data, _ := yamlv2.Marshal(field)
+	return string(data)
+}
+
+// TypeMap returns a map from every leaf key to the type it is mapped to.
+// Fields without a type are mapped to keyword, or to object for disabled
+// groups, aliases to the type of the field they point to. If a key is defined
+// multiple times, the first definition is used.
+func (f Fields) TypeMap() map[string]string {
+	types := map[string]string{}
+	f.walkLeaves("", func(key string, field Field) {
+		if _, exists := types[key]; !exists {
+			types[key] = f.effectiveType(field)
+		}
+	})
+	return types
+}
+
+// AllTypes returns the sorted set of types the leaf fields of the tree,
+// including multi-fields, are mapped to. As in TypeMap, fields without a type
+// are reported as keyword and aliases as the type of the field they point to.
+// Disabled groups are not indexed and don't report any type.
+func (f Fields) AllTypes() []string {
+	seen := map[string]bool{}
+	f.walkLeaves("", func(key string, field Field) {
+		isGroup := field.Type == "group" || (field.Type == "" && len(field.Fields) > 0)
+		if !isGroup {
+			seen[f.effectiveType(field)] = true
+		}
+	})
+
+	var types []string
+	for t := range seen {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Dimensions returns the keys of the fields marked as time series dimensions,
+// including multi-fields, in the order of GetKeys.
+func (f Fields) Dimensions() []string {
+	var keys []string
+	f.walkLeaves("", func(key string, field Field) {
+		if field.TimeSeriesDimension != nil && *field.TimeSeriesDimension {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// fieldPath holds the fields on the path of a recursive walk. It is used to
+// detect fields which are nested in themselves, as it can happen when slices
+// are shared between fields.
+type fieldPath map[*Field]struct{}
+
+// enter adds the field to the path. It returns false if the field is already
+// part of the path.
+func (p fieldPath) enter(f *Field) bool {
+	if _, exists := p[f]; exists {
+		return false
+	}
+	p[f] = struct{}{}
+	return true
+}
+
+// leave removes the field from the path.
+func (p fieldPath) leave(f *Field) {
+	delete(p, f)
+}
+
+// DefaultFields returns the keys of all the fields to be included in the
+// index.query.default_field setting, as reported by Field.IsDefaultField.
+func (f Fields) DefaultFields() []string {
+	var keys []string
+	f.walkLeaves("", func(key string, field Field) {
+		if field.IsDefaultField() {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// Count returns the number of leaf fields, including multi-fields. It matches
+// the number of keys returned by GetKeys.
+func (f Fields) Count() int {
+	count := 0
+	f.walkLeaves("", func(string, Field) {
+		count++
+	})
+	return count
+}
+
+// CountByType returns the number of leaf fields, including multi-fields, per
+// type. Fields without a type are counted as keyword.
+func (f Fields) CountByType() map[string]int {
+	counts := map[string]int{}
+	f.walkLeaves("", func(_ string, field Field) {
+		fieldType := field.Type
+		if fieldType == "" {
+			fieldType = "keyword"
+		}
+		counts[fieldType]++
+	})
+	return counts
+}
+
+// Subtree returns a copy of the fields of the group found under the given
+// dotted key, so the keys of the returned Fields are relative to the group.
+// If the group is defined multiple times, the fields of all definitions are
+// returned. False is returned if the key does not resolve to a group.
+func (f Fields) Subtree(key string) (Fields, bool) {
+	var subtree Fields
+	found := false
+	f.subtree("", key, fieldPath{}, func(group *Field) {
+		subtree = append(subtree, group.Fields.clone(fieldPath{})...)
+		found = true
+	})
+	return subtree, found
+}
+
+func (f Fields) subtree(namespace, key string, path fieldPath, fn func(group *Field)) {
+	for i := range f {
+		field := &f[i]
+		if len(field.Fields) == 0 || !path.enter(field) {
+			continue
+		}
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+		if fieldName == key {
+			fn(field)
+		} else if strings.HasPrefix(key, fieldName+".") {
+			field.Fields.subtree(fieldName, key, path, fn)
+		}
+		path.leave(field)
+	}
+}
+
+// RootNamespace is the key under which GroupByNamespace returns the top-level
+// fields without children.
+const RootNamespace = ""
+
+// GroupByNamespace splits the tree by top-level namespace. Every top-level
+// group is returned under the first segment of its name, together with the
+// other groups of the same namespace. The groups are kept in the returned
+// Fields, so their keys are the same as in the tree. Top-level fields without
+// children are returned under RootNamespace. The returned fields are a copy of
+// the tree.
+func (f Fields) GroupByNamespace() map[string]Fields {
+	namespaces := map[string]Fields{}
+	for _, field := range f.clone(fieldPath{}) {
+		namespace := RootNamespace
+		if len(field.Fields) > 0 {
+			namespace = strings.SplitN(field.Name, ".", 2)[0]
+		}
+		namespaces[namespace] = append(namespaces[namespace], field)
+	}
+	return namespaces
+}
+
+// Equal returns true if both fields have the same name and attributes,
+// except for their descriptions, and if their nested fields and multi-fields
+// are equal. The order of the nested fields and multi-fields is not taken into
+// account, so fields which are equal have the same fingerprint.
+func (f Field) Equal(other Field) bool {
+	return bytes.Equal(Fields{f}.canonicalYAML(), Fields{other}.canonicalYAML())
+}
+
+// NestedPaths returns the keys of all fields of type nested, in declaration
+// order.
+func (f Fields) NestedPaths() []string {
+	var paths []string
+	f.walkNested("", "", fieldPath{}, func(key, _ string, field *Field) {
+		if field.Type == "nested" {
+			paths = append(paths, key)
+		}
+	})
+	return paths
+}
+
+// NestedParents returns for every leaf key under a nested field the key of
+// its nearest nested ancestor.
+func (f Fields) NestedParents() map[string]string {
+	parents := map[string]string{}
+	f.walkNested("", "", fieldPath{}, func(key, parent string, field *Field) {
+		if len(field.Fields) == 0 && parent != "" {
+			parents[key] = parent
+		}
+	})
+	return parents
+}
+
+// walkNested calls fn for every field, including groups and multi-fields,
+// with the key of its nearest nested ancestor.
+func (f Fields) walkNested(namespace, parent string, path fieldPath, fn func(key, parent string, field *Field)) {
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+		fn(fieldName, parent, field)
+
+		childParent := parent
+		if field.Type == "nested" {
+			childParent = fieldName
+		}
+		field.Fields.walkNested(fieldName, childParent, path, fn)
+		field.MultiFields.walkNested(fieldName, childParent, path, fn)
+		path.leave(field)
+	}
+}
+
+// Walk calls fn for every field in the tree, depth-first and in declaration
+// order, with its full dotted key. Groups are visited before their children
+// and leaves before their multi-fields. Walking stops at the first error
+// returned by fn, which is returned as is.
+func (f Fields) Walk(fn func(key string, field Field) error) error {
+	return f.walk("", fieldPath{}, fn)
+}
+
+func (f Fields) walk(namespace string, path fieldPath, fn func(key string, field Field) error) error {
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+		err := fn(fieldName, *field)
+		if err == nil {
+			err = field.Fields.walk(fieldName, path, fn)
+		}
+		if err == nil {
+			err = field.MultiFields.walk(fieldName, path, fn)
+		}
+		path.leave(field)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameKey returns a copy of the tree with the field found under oldKey, a
+// leaf or a group, moved to newKey. Missing groups in newKey are created and
+// groups left empty by the move are removed. An error is returned if oldKey
+// does not exist or newKey already does.
+func (f Fields) RenameKey(oldKey, newKey string) (Fields, error) {
+	return f.renameKey(oldKey, newKey, false)
+}
+
+// RenameKeyWithAlias is like RenameKey, but also adds an alias field under
+// oldKey pointing to newKey. Only leaf fields can be aliased.
+func (f Fields) RenameKeyWithAlias(oldKey, newKey string) (Fields, error) {
+	return f.renameKey(oldKey, newKey, true)
+}
+
+func (f Fields) renameKey(oldKey, newKey string, alias bool) (Fields, error) {
+	renamed := f.clone(fieldPath{})
+	field, found := renamed.remove(strings.Split(oldKey, "."))
+	if !found {
+		return nil, errors.Errorf("field '%s' not found", oldKey)
+	}
+	if renamed.HasNode(newKey) {
+		return nil, errors.Errorf("field '%s' already exists", newKey)
+	}
+	if alias && len(field.Fields) > 0 {
+		return nil, errors.Errorf("field '%s' is a group and cannot be aliased", oldKey)
+	}
+
+	renamed = renamed.insert(strings.Split(newKey, "."), field)
+	if alias {
+		renamed = renamed.insert(strings.Split(oldKey, "."), Field{Type: "alias", AliasPath: newKey})
+	}
+	return renamed, nil
+}
+
+// remove removes the first field found under the given key segments and
+// returns it. Groups left without fields are removed too.
+func (f *Fields) remove(keys []string) (Field, bool) {
+	for i := range *f {
+		field := &(*f)[i]
+		if field.Name != keys[0] {
+			continue
+		}
+		if len(keys) == 1 {
+			removed := *field
+			*f = append((*f)[:i:i], (*f)[i+1:]...)
+			return removed, true
+		}
+		if removed, found := field.Fields.remove(keys[1:]); found {
+			if len(field.Fields) == 0 {
+				*f = append((*f)[:i:i], (*f)[i+1:]...)
+			}
+			return removed, true
+		}
+	}
+	return Field{}, false
+}
+
+// insert adds the field under the given key segments, creating the missing
+// groups, and returns the resulting Fields.
+func (f Fields) insert(keys []string, field Field) Fields {
+	if len(keys) == 1 {
+		field.Name = keys[0]
+		return append(f, field)
+	}
+
+	idx := f.indexOf(keys[0])
+	if idx < 0 {
+		f = append(f, Field{Name: keys[0], Type: "group"})
+		idx = len(f) - 1
+	}
+	f[idx].Fields = f[idx].Fields.insert(keys[1:], field)
+	return f
+}
+
+// ApplyDefaults returns a copy of the tree where leaf fields without a type,
+// including multi-fields, get the keyword type they are mapped to. Groups and
+// fields with a type are left untouched.
+func (f Fields) ApplyDefaults() Fields {
+	fields := f.clone(fieldPath{})
+	fields.applyDefaults()
+	return fields
+}
+
+func (f Fields) applyDefaults() {
+	for i := range f {
+		field := &f[i]
+		if field.Type == "" && len(field.Fields) == 0 {
+			field.Type = "keyword"
+		}
+		field.Fields.applyDefaults()
+		field.MultiFields.applyDefaults()
+	}
+}
+
+// DefaultIgnoreMalformed returns a copy of the tree where ignore_malformed is
+// set to the given value for all fields supporting it that don't set it
+// themselves, including multi-fields. Alternatively the index setting
+// `index.mapping.ignore_malformed` can be used.
+func (f Fields) DefaultIgnoreMalformed(ignore bool) Fields {
+	fields := f.clone(fieldPath{})
+	fields.defaultIgnoreMalformed(ignore)
+	return fields
+}
+
+func (f Fields) defaultIgnoreMalformed(ignore bool) {
+	for i := range f {
+		field := &f[i]
+		if field.IgnoreMalformed == nil && containsString(ignoreMalformedTypes, field.Type) {
+			value := ignore
+			field.IgnoreMalformed = &value
+		}
+		field.Fields.defaultIgnoreMalformed(ignore)
+		field.MultiFields.defaultIgnoreMalformed(ignore)
+	}
+}
+
+// StripDescriptions returns a copy of the tree with the descriptions of all
+// fields, including groups and multi-fields, removed. Descriptions are only
+// needed for documentation, so stripping them reduces the memory used by
+// fields kept at runtime once the original tree is released.
+func (f Fields) StripDescriptions() Fields {
+	fields := f.clone(fieldPath{})
+	fields.stripDescriptions()
+	return fields
+}
+
+func (f Fields) stripDescriptions() {
+	for i := range f {
+		f[i].Description = ""
+		f[i].Fields.stripDescriptions()
+		f[i].MultiFields.stripDescriptions()
+	}
+}
+
+// FieldDescriptor describes a leaf field for listing it in a user interface.
+type FieldDescriptor struct {
+	Name         string
+	Type         string
+	Aggregatable bool
+	Searchable   bool
+	Description  string
+}
+
+// Describe returns a descriptor for every leaf field, including multi-fields,
+// in the order of GetKeys. Fields without a type are described as keyword.
+// Unless set explicitly with the aggregatable and searchable attributes, text
+// and binary fields and fields without doc_values are not aggregatable, and
+// binary fields and fields which are not indexed are not searchable.
+func (f Fields) Describe() []FieldDescriptor {
+	var descriptors []FieldDescriptor
+	f.walkLeaves("", func(key string, field Field) {
+		fieldType := field.Type
+		if fieldType == "" {
+			fieldType = "keyword"
+		}
+
+		aggregatable := fieldType != "text" && fieldType != "binary" &&
+			(field.DocValues == nil || *field.DocValues)
+		if field.Aggregatable != nil {
+			aggregatable = *field.Aggregatable
+		}
+
+		searchable := fieldType != "binary" && (field.Index == nil || *field.Index)
+		if field.Searchable != nil {
+			searchable = *field.Searchable
+		}
+
+		descriptors = append(descriptors, FieldDescriptor{
+			Name:         key,
+			Type:         fieldType,
+			Aggregatable: aggregatable,
+			Searchable:   searchable,
+			Description:  field.Description,
+		})
+	})
+	return descriptors
+}
+
+// Prune returns a copy of the tree without groups left with no fields.
+// Groups are removed recursively, so a group only containing empty groups is
+// removed too. Fields without a type are only considered a group if
+// they declare fields, otherwise they are keyword leaves and are kept.
+func (f Fields) Prune() Fields {
+	return f.clone(fieldPath{}).prune()
+}
+
+func (f Fields) prune() Fields {
+	var pruned Fields
+	for _, field := range f {
+		isGroup := field.Type == "group" || (field.Type == "" && len(field.Fields) > 0)
+		field.Fields = field.Fields.prune()
+		if isGroup && len(field.Fields) == 0 {
+			continue
+		}
+		pruned = append(pruned, field)
+	}
+	return pruned
+}
+
+// MarshalYAML implements the yaml.Marshaler interface, writing the field in
+// the fields.yml format. Attributes are written in declaration order, and
+// only if they are set, so the output is stable.
+func (f Field) MarshalYAML() (interface{}, error) {
+	return marshalConfigYAML(f), nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface, writing the
+// configuration in the fields.yml format.
+func (c ObjectTypeCfg) MarshalYAML() (interface{}, error) {
+	return marshalConfigYAML(c), nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface, writing the
+// versionized string in the fields.yml format.
+func (s VersionizedString) MarshalYAML() (interface{}, error) {
+	return marshalConfigYAML(s), nil
+}
+
+// marshalConfigYAML returns the fields of the struct v keyed by the name of
+// their config tag, in declaration order. Fields without a config tag, zero
+// values and empty slices are left out.
+func marshalConfigYAML(v interface{}) yamlv2.MapSlice {
+	rv := reflect.ValueOf(v)
+	rt := rv.Type()
+
+	var out yamlv2.MapSlice
+	for i := 0; i < rt.NumField(); i++ {
+		name := configName(rt.Field(i))
+		if name == "" {
+			continue
+		}
+
+		value := rv.Field(i)
+		if isUnset(value) {
+			continue
+		}
+		out = append(out, yamlv2.MapItem{Key: name, Value: value.Interface()})
+	}
+	return out
+}
+
+// configName returns the name of the setting a struct field is unpacked from.
+func configName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("config"), ",")[0]
+}
+
+// isUnset returns true if value is the zero value of its type or an empty
+// slice or map.
+func isUnset(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface())
+}
+
+// NormalizeNames returns a copy of the tree with all field names lowercased,
+// as required by ECS, and the dotted keys of the fields whose name was
+// changed. An error is returned if fields of the same group get the same name
+// only after lowercasing, like Host and host.
+func (f Fields) NormalizeNames() (Fields, []string, error) {
+	normalized := f.clone(fieldPath{})
+	var changed []string
+	if err := normalized.normalizeNames("", &changed); err != nil {
+		return nil, nil, err
+	}
+	return normalized, changed, nil
+}
+
+func (f Fields) normalizeNames(namespace string, changed *[]string) error {
+	originals := map[string]string{}
+	for i := range f {
+		field := &f[i]
+		fieldName := namespace + "." + field.Name
+		if namespace == "" {
+			fieldName = field.Name
+		}
+
+		name := strings.ToLower(field.Name)
+		if original, found := originals[name]; found && original != fieldName {
+			return errors.Errorf("fields '%s' and '%s' collide once lowercased", original, fieldName)
+		}
+		originals[name] = fieldName
+		if name != field.Name {
+			*changed = append(*changed, fieldName)
+			field.Name = name
+		}
+
+		if err := field.Fields.normalizeNames(fieldName, changed); err != nil {
+			return err
+		}
+		if err := field.MultiFields.normalizeNames(fieldName, changed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MetaFor returns a copy of the metadata of the field, leaf or group, with the
+// given dotted key. If the key is defined multiple times, the first definition
+// with metadata is used. False is returned if no metadata is found.
+func (f Fields) MetaFor(key string) (MapStr, bool) {
+	var meta MapStr
+	errFound := errors.New("found")
+	err := f.Walk(func(k string, field Field) error {
+		if k == key && field.Meta != nil {
+			meta = field.Meta.Clone()
+			return errFound
+		}
+		return nil
+	})
+	return meta, err == errFound
+}
+
+// ValidateAgainstECS checks that the tree doesn't redefine fields of the
+// given ECS fields with an incompatible type. Types are compared as reported
+// by TypeMap. An error is returned for every leaf field with a different
+// type than the ECS field with the same key, defined where ECS defines an
+// object, or nested below an ECS leaf field.
+func (f Fields) ValidateAgainstECS(ecs Fields) error {
+	ecsTypes := ecs.TypeMap()
+
+	var errs multierror.Errors
+	f.walkLeaves("", func(key string, field Field) {
+		fieldType := f.effectiveType(field)
+		if ecsType, found := ecsTypes[key]; found {
+			if ecsType != fieldType {
+				errs = append(errs, errors.Errorf("field '%s' of type '%s' conflicts with ECS field of type '%s'", key, fieldType, ecsType))
+			}
+			return
+		}
+		if _, found := ecs.Subtree(key); found {
+			errs = append(errs, errors.Errorf("field '%s' of type '%s' conflicts with ECS object '%s'", key, fieldType, key))
+			return
+		}
+		for idx := strings.LastIndexByte(key, '.'); idx > 0; idx = strings.LastIndexByte(key[:idx], '.') {
+			if ecsType, found := ecsTypes[key[:idx]]; found {
+				errs = append(errs, errors.Errorf("field '%s' is nested under ECS field '%s' of type '%s'", key, key[:idx], ecsType))
+				return
+			}
+		}
+	})
+	return errs.Err()
+}
+
+// ExportJSONSchema returns a JSON Schema describing the shape of the events
+// documented by the fields. Groups are described as objects with their child
+// fields as properties, aliases take the type of the field they point to.
+// Multi-fields are not part of the events and are ignored. As Elasticsearch
+// accepts arrays for any field, the schema describes single values only.
+func (f Fields) ExportJSONSchema() ([]byte, error) {
+	schema := MapStr{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type":    "object",
+	}
+	f.jsonSchema(f, fieldPath{}, schema)
+	return json.Marshal(schema)
+}
+
+func (f Fields) jsonSchema(root Fields, path fieldPath, schema MapStr) {
+	for i := range f {
+		field := &f[i]
+		if !path.enter(field) {
+			continue
+		}
+		node := schema
+		for _, name := range strings.Split(field.Name, ".") {
+			node = jsonSchemaProperty(node, name)
+		}
+		if field.Description != "" {
+			node["description"] = strings.TrimSpace(field.Description)
+		}
+		if field.isLeaf() {
+			node.Update(jsonSchemaType(root.effectiveType(*field)))
+		} else {
+			field.Fields.jsonSchema(root, path, node)
+		}
+		path.leave(field)
+	}
+}
+
+// jsonSchemaProperty returns the schema of the property name of the object
+// described by schema, adding it if missing.
+func jsonSchemaProperty(schema MapStr, name string) MapStr {
+	properties, ok := schema["properties"].(MapStr)
+	if !ok {
+		properties = MapStr{}
+		schema["type"] = "object"
+		schema["properties"] = properties
+	}
+	property, ok := properties[name].(MapStr)
+	if !ok {
+		property = MapStr{}
+		properties[name] = property
+	}
+	return property
+}
+
+// jsonSchemaType returns the JSON Schema type of values of the given field
+// type. Values of unknown types are not restricted.
+func jsonSchemaType(fieldType string) MapStr {
+	switch fieldType {
+	case "keyword", "text", "ip", "binary":
+		return MapStr{"type": "string"}
+	case "date":
+		return MapStr{"type": "string", "format": "date-time"}
+	case "long", "integer", "short", "byte":
+		return MapStr{"type": "integer"}
+	case "float", "double", "half_float", "scaled_float":
+		return MapStr{"type": "number"}
+	case "boolean":
+		return MapStr{"type": "boolean"}
+	case "group", "object", "nested":
+		return MapStr{"type": "object"}
+	case "array":
+		return MapStr{"type": "array"}
+	default:
+		return MapStr{}
+	}
+}
+
+// ValidateEvent checks the values of the event against the types declared in
+// the tree and returns an error for every value that can not be indexed as its
+// declared type, e.g. a string for a long field. Keys not defined in the tree
+// are dynamic fields and are not checked. Aliases are checked against the type
+// of the field they point to. Values of slices are checked one by one. Values
+// decoded as json.Number are checked as the number they hold, and numbers are
+// accepted for text and keyword fields, as Elasticsearch coerces them to
+// strings. Errors are sorted by key.
+func (f Fields) ValidateEvent(event MapStr) []error {
+	index := f.BuildIndex()
+	errs := map[string]error{}
+	f.validateEvent(index, "", event, errs)
+
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var list []error
+	for _, key := range keys {
+		list = append(list, errs[key])
+	}
+	return list
+}
+
+func (f Fields) validateEvent(index FieldIndex, prefix string, event MapStr, errs map[string]error) {
+	for k, value := range event {
+		key := prefix + k
+		field, found := index.Get(key)
+		if !found {
+			if m, ok := tryToMapStr(value); ok {
+				f.validateEvent(index, key+".", m, errs)
+			}
+			continue
+		}
+
+		fieldType := f.effectiveType(field)
+		if !valueMatchesType(value, fieldType) {
+			errs[key] = errors.Errorf("field '%s' has a value of type %T, but is declared as %s", key, value, fieldType)
+		}
+	}
+}
+
+// valueMatchesType checks if the value can be indexed as the given field type.
+// Types that are not checked and null values always match.
+func valueMatchesType(value interface{}, fieldType string) bool {
+	if value == nil {
+		return true
+	}
+	if n, ok := value.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			value = i
+		} else if f, err := n.Float64(); err == nil {
+			value = f
+		}
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < rv.Len(); i++ {
+			if !valueMatchesType(rv.Index(i).Interface(), fieldType) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch fieldType {
+	case "long":
+		return isIntegerInRange(rv, math.MinInt64, math.MaxInt64)
+	case "integer":
+		return isIntegerInRange(rv, math.MinInt32, math.MaxInt32)
+	case "short":
+		return isIntegerInRange(rv, math.MinInt16, math.MaxInt16)
+	case "byte":
+		return isIntegerInRange(rv, math.MinInt8, math.MaxInt8)
+	case "double", "float", "half_float", "scaled_float":
+		return isNumber(rv)
+	case "keyword":
+		_, isMap := tryToMapStr(value)
+		return !isMap
+	case "text":
+		return rv.Kind() == reflect.String || isNumber(rv)
+	case "boolean":
+		return rv.Kind() == reflect.Bool
+	case "date":
+		switch v := value.(type) {
+		case time.Time, Time:
+			return true
+		case string:
+			for _, layout := range []string{time.RFC3339Nano, time.RFC3339} {
+				if _, err := time.Parse(layout, v); err == nil {
+					return true
+				}
+			}
+			return false
+		}
+		return isIntegerInRange(rv, math.MinInt64, math.MaxInt64)
+	case "ip":
+		switch v := value.(type) {
+		case net.IP:
+			return true
+		case string:
+			return net.ParseIP(v) != nil
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// isNumber checks if the value is an integer or a float.
+func isNumber(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// isIntegerInRange checks if the value is an integer between min and max.
+// Floats without a fractional part are accepted.
+func isIntegerInRange(rv reflect.Value, min, max int64) bool {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() >= min && rv.Int() <= max
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() <= uint64(max)
+	case reflect.Float32, reflect.Float64:
+		v := rv.Float()
+		return v == math.Trunc(v) && v >= float64(min) && v <= float64(max)
+	}
+	return false
 }
@@ -18,11 +18,21 @@
 package common
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/joeshaw/multierror"
 	"github.com/stretchr/testify/require"
 
 	"github.com/stretchr/testify/assert"
+	yamlv2 "gopkg.in/yaml.v2"
 
 	"github.com/elastic/go-ucfg/yaml"
 )
@@ -72,6 +82,32 @@ func TestFieldsHasKey(t *testing.T) {
 			},
 			result: false,
 		},
+		{
+			key: "message.keyword",
+			fields: Fields{
+				Field{
+					Name: "message", Type: "text", MultiFields: Fields{
+						Field{
+							Name: "keyword", Type: "keyword",
+						},
+					},
+				},
+			},
+			result: true,
+		},
+		{
+			key: "message.raw",
+			fields: Fields{
+				Field{
+					Name: "message", Type: "text", MultiFields: Fields{
+						Field{
+							Name: "keyword", Type: "keyword",
+						},
+					},
+				},
+			},
+			result: false,
+		},
 	}
 
 	for _, test := range tests {
@@ -79,10 +115,61 @@ func TestFieldsHasKey(t *testing.T) {
 	}
 }
 
+func TestFieldsHasKeyFold(t *testing.T) {
+	fields := Fields{
+		Field{
+			Name: "test", Fields: Fields{
+				Field{
+					Name: "find",
+				},
+			},
+		},
+		Field{
+			Name: "Host", Fields: Fields{
+				Field{Name: "ip", Type: "ip"},
+			},
+		},
+		Field{
+			Name: "host", Fields: Fields{
+				Field{Name: "name"},
+			},
+		},
+		Field{
+			Name: "message", Type: "text", MultiFields: Fields{
+				Field{Name: "keyword", Type: "keyword"},
+			},
+		},
+	}
+
+	tests := []struct {
+		key    string
+		result bool
+	}{
+		{key: "test.find", result: true},
+		{key: "Test.Find", result: true},
+		{key: "TEST.FIND", result: true},
+		{key: "tEsT.fInD", result: true},
+		{key: "Test", result: false},
+		{key: "Test.Find.More", result: false},
+		{key: "test.finder", result: false},
+		{key: "HOST.NAME", result: true},
+		{key: "host.IP", result: true},
+		{key: "Message.Keyword", result: true},
+		{key: "", result: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.result, fields.HasKeyFold(test.key), test.key)
+	}
+
+	assert.False(t, fields.HasKey("Test.Find"))
+}
+
 func TestDynamicYaml(t *testing.T) {
 	tests := []struct {
 		input  []byte
 		output Field
+		isSet  bool
 		error  bool
 	}{
 		{
@@ -93,6 +180,7 @@ dynamic: true`),
 				Name:    "test",
 				Dynamic: DynamicType{true},
 			},
+			isSet: true,
 		},
 		{
 			input: []byte(`
@@ -102,6 +190,7 @@ dynamic: "true"`),
 				Name:    "test",
 				Dynamic: DynamicType{true},
 			},
+			isSet: true,
 		},
 		{
 			input: []byte(`
@@ -117,6 +206,25 @@ dynamic: "strict"`),
 				Name:    "test",
 				Dynamic: DynamicType{"strict"},
 			},
+			isSet: true,
+		},
+		{
+			input: []byte(`
+name: test
+dynamic: false`),
+			output: Field{
+				Name:    "test",
+				Dynamic: DynamicType{false},
+			},
+			isSet: true,
+		},
+		{
+			input: []byte(`
+name: test`),
+			output: Field{
+				Name: "test",
+			},
+			isSet: false,
 		},
 	}
 
@@ -131,10 +239,72 @@ dynamic: "strict"`),
 			assert.True(t, test.error)
 		} else {
 			assert.Equal(t, test.output.Dynamic, keys.Dynamic)
+			assert.Equal(t, test.isSet, keys.Dynamic.IsSet())
 		}
 	}
 }
 
+func TestDynamicTypeMarshal(t *testing.T) {
+	tests := []struct {
+		dynamic DynamicType
+		str     string
+		yaml    string
+		json    string
+	}{
+		{DynamicType{true}, "true", "dynamic: true\n", `{"Dynamic":true}`},
+		{DynamicType{false}, "false", "dynamic: false\n", `{"Dynamic":false}`},
+		{DynamicType{"strict"}, "strict", "dynamic: strict\n", `{"Dynamic":"strict"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.str, func(t *testing.T) {
+			assert.Equal(t, test.str, test.dynamic.String())
+
+			field := struct{ Dynamic DynamicType }{test.dynamic}
+
+			out, err := yamlv2.Marshal(field)
+			require.NoError(t, err)
+			assert.Equal(t, test.yaml, string(out))
+
+			var fromYAML struct{ Dynamic DynamicType }
+			require.NoError(t, yamlv2.Unmarshal(out, &fromYAML))
+			assert.Equal(t, field, fromYAML)
+
+			out, err = json.Marshal(field)
+			require.NoError(t, err)
+			assert.Equal(t, test.json, string(out))
+
+			var fromJSON struct{ Dynamic DynamicType }
+			require.NoError(t, json.Unmarshal(out, &fromJSON))
+			assert.Equal(t, field, fromJSON)
+
+			cfg, err := yaml.NewConfig([]byte(test.yaml))
+			require.NoError(t, err)
+			var f Field
+			require.NoError(t, cfg.Unpack(&f))
+			assert.Equal(t, test.dynamic, f.Dynamic)
+		})
+	}
+
+	assert.Equal(t, "", DynamicType{}.String())
+
+	var field Field
+	require.NoError(t, json.Unmarshal([]byte(`{"Dynamic":"true"}`), &field))
+	assert.Equal(t, DynamicType{true}, field.Dynamic)
+	require.NoError(t, yamlv2.Unmarshal([]byte(`dynamic: "false"`), &struct{ Dynamic *DynamicType }{&field.Dynamic}))
+	assert.Equal(t, DynamicType{false}, field.Dynamic)
+	assert.Error(t, json.Unmarshal([]byte(`{"Dynamic":"yes"}`), &field))
+	assert.Error(t, json.Unmarshal([]byte(`{"Dynamic":1}`), &field))
+
+	// Fields with a dynamic setting round trip through JSON.
+	original := Field{Name: "labels", Type: "object", Dynamic: DynamicType{"strict"}}
+	out, err := json.Marshal(original)
+	require.NoError(t, err)
+	var decoded Field
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, original, decoded)
+}
+
 func TestGetKeys(t *testing.T) {
 	tests := []struct {
 		fields Fields
@@ -185,14 +355,126 @@ func TestGetKeys(t *testing.T) {
 			},
 			keys: []string{"a", "b", "c"},
 		},
+		{
+			fields: Fields{
+				Field{
+					Name: "message", Type: "text", MultiFields: Fields{
+						Field{
+							Name: "keyword", Type: "keyword",
+						},
+					},
+				},
+				Field{
+					Name: "b",
+				},
+			},
+			keys: []string{"message", "message.keyword", "b"},
+		},
 	}
 
 	for _, test := range tests {
 		assert.Equal(t, test.keys, test.fields.GetKeys())
+
+		var keys []string
+		test.fields.Keys(func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		assert.Equal(t, test.keys, keys)
+	}
+}
+
+func TestFieldsKeysStop(t *testing.T) {
+	fields := Fields{
+		Field{Name: "a", Fields: Fields{
+			Field{Name: "b", MultiFields: Fields{
+				Field{Name: "c"},
+			}},
+			Field{Name: "d"},
+		}},
+		Field{Name: "e"},
+	}
+
+	var keys []string
+	fields.Keys(func(key string) bool {
+		keys = append(keys, key)
+		return key != "a.b.c"
+	})
+	assert.Equal(t, []string{"a.b", "a.b.c"}, keys)
+}
+
+func TestFieldIsMultiField(t *testing.T) {
+	assert.False(t, Field{Name: "message", Type: "text"}.IsMultiField())
+	assert.True(t, Field{Name: "message", Type: "text", MultiFields: Fields{{Name: "keyword", Type: "keyword"}}}.IsMultiField())
+}
+
+func TestGetKeysWithGroups(t *testing.T) {
+	tests := []struct {
+		fields Fields
+		keys   []string
+	}{
+		{
+			fields: Fields{
+				Field{
+					Name: "test", Fields: Fields{
+						Field{
+							Name: "find",
+						},
+					},
+				},
+			},
+			keys: []string{"test", "test.find"},
+		},
+		{
+			fields: Fields{
+				Field{
+					Name: "a", Fields: Fields{
+						Field{
+							Name: "b",
+						},
+					},
+				},
+				Field{
+					Name: "a", Fields: Fields{
+						Field{
+							Name: "c",
+						},
+					},
+				},
+			},
+			keys: []string{"a", "a.b", "a.c"},
+		},
+		{
+			fields: Fields{
+				Field{
+					Name: "a", Type: "object", Fields: Fields{
+						Field{
+							Name: "b", Fields: Fields{
+								Field{
+									Name: "c",
+								},
+							},
+						},
+					},
+				},
+				Field{
+					Name: "d",
+				},
+			},
+			keys: []string{"a", "a.b", "a.b.c", "d"},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.keys, test.fields.GetKeysWithGroups())
 	}
 }
 
 func TestFieldValidate(t *testing.T) {
+	falseVar := false
+	trueVar := true
+	boost := 2.0
+
 	tests := []struct {
 		cfg   MapStr
 		field Field
@@ -228,6 +510,227 @@ func TestFieldValidate(t *testing.T) {
 				"object_type_params": []MapStr{{"object_type": "scaled_float", "object_type_mapping_type": "float"}}},
 			err:  true,
 			name: "invalid config mixing scaling_factor and object_type_params",
+		}, {
+			cfg:   MapStr{"object_type": "long", "object_type_mapping_type": "*"},
+			field: Field{ObjectType: "long", ObjectTypeMappingType: "*"},
+			err:   false,
+			name:  "wildcard object_type_mapping_type",
+		}, {
+			cfg:  MapStr{"object_type": "scaled_float", "object_type_mapping_type": "flaot"},
+			err:  true,
+			name: "invalid config unknown object_type_mapping_type",
+		}, {
+			cfg: MapStr{"object_type_params": []MapStr{
+				{"object_type": "scaled_float", "object_type_mapping_type": "flaot"}}},
+			err:  true,
+			name: "invalid config unknown object_type_mapping_type in object_type_params",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "scaled_float", "scaling_factor": 100},
+			field: Field{Name: "test", Type: "scaled_float", ScalingFactor: 100},
+			err:   false,
+			name:  "scaling_factor for scaled_float",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "scaled_float", "scaling_factor": 0.5},
+			field: Field{Name: "test", Type: "scaled_float", ScalingFactor: 0.5},
+			err:   false,
+			name:  "float scaling_factor for scaled_float",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "scaled_float", "scaling_factor": -10},
+			err:  true,
+			name: "invalid config negative scaling_factor",
+		}, {
+			cfg: MapStr{"object_type_params": []MapStr{
+				{"object_type": "scaled_float", "scaling_factor": -1.5}}},
+			err:  true,
+			name: "invalid config negative scaling_factor in object_type_params",
+		}, {
+			cfg: MapStr{"object_type_params": []MapStr{
+				{"object_type": "scaled_float", "match_pattern": "*.pct"}}},
+			field: Field{ObjectTypeParams: []ObjectTypeCfg{{ObjectType: "scaled_float", MatchPattern: "*.pct"}}},
+			err:   false,
+			name:  "match_pattern in object_type_params",
+		}, {
+			cfg: MapStr{"object_type_params": []MapStr{
+				{"scaling_factor": 10}}},
+			err:  true,
+			name: "invalid config object_type_params without type or pattern",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "float", "scaling_factor": 100},
+			err:  true,
+			name: "invalid config scaling_factor for float",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "object", "object_type": "float", "scaling_factor": 100},
+			err:  true,
+			name: "invalid config scaling_factor for float object_type",
+		}, {
+			cfg:   MapStr{"name": "test", "ignore_above": 2048},
+			field: Field{Name: "test", IgnoreAbove: 2048},
+			err:   false,
+			name:  "ignore_above for default type",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "keyword", "ignore_above": -1},
+			field: Field{Name: "test", Type: "keyword", IgnoreAbove: -1},
+			err:   false,
+			name:  "ignore_above disabled for keyword",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "text", "ignore_above": 2048},
+			err:  true,
+			name: "invalid config ignore_above for text",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "text", "index": false},
+			field: Field{Name: "test", Type: "text", Index: &falseVar},
+			err:   false,
+			name:  "index disabled",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "text"},
+			field: Field{Name: "test", Type: "text"},
+			err:   false,
+			name:  "index not set",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "date", "date_format": "epoch_millis"},
+			field: Field{Name: "test", Type: "date", DateFormat: "epoch_millis"},
+			err:   false,
+			name:  "date_format for date",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "long", "date_format": "epoch_millis"},
+			err:  true,
+			name: "invalid config date_format for long",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "long", "format": "bytes"},
+			field: Field{Name: "test", Type: "long", Format: "bytes"},
+			err:   false,
+			name:  "kibana format for long",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "keyword", "doc_values": false, "aggregatable": false},
+			field: Field{Name: "test", Type: "keyword", DocValues: &falseVar, Aggregatable: &falseVar},
+			err:   false,
+			name:  "doc_values disabled for non aggregatable field",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "keyword", "doc_values": false, "aggregatable": true},
+			err:  true,
+			name: "invalid config doc_values disabled for aggregatable field",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "string", "ignore_above": 256},
+			field: Field{Name: "test", Type: "string", IgnoreAbove: 256},
+			err:   false,
+			name:  "deprecated type validated as its replacement",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "text", "analyzer": "simple", "search_analyzer": "whitespace"},
+			field: Field{Name: "test", Type: "text", Analyzer: "simple", SearchAnalyzer: "whitespace"},
+			err:   false,
+			name:  "analyzers for text",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "keyword", "analyzer": "simple"},
+			err:  true,
+			name: "invalid config analyzer for keyword",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "text", "analyzer": " "},
+			err:  true,
+			name: "invalid config blank analyzer",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "text", "search_analyzer": "whitespace"},
+			err:  true,
+			name: "invalid config search_analyzer without analyzer",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "text", "similarity": "BM25", "term_vector": "with_positions"},
+			field: Field{Name: "test", Type: "text", Similarity: "BM25", TermVector: "with_positions"},
+			err:   false,
+			name:  "similarity and term_vector for text",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "text", "similarity": "classic"},
+			err:  true,
+			name: "invalid config unknown similarity",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "text", "term_vector": "always"},
+			err:  true,
+			name: "invalid config unknown term_vector",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "keyword", "similarity": "boolean"},
+			err:  true,
+			name: "invalid config similarity for keyword",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "long", "coerce": false},
+			field: Field{Name: "test", Type: "long", Coerce: &falseVar},
+			err:   false,
+			name:  "coerce for long",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "keyword", "coerce": false},
+			err:  true,
+			name: "invalid config coerce for keyword",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "long", "time_series_metric": "counter"},
+			field: Field{Name: "test", Type: "long", TimeSeriesMetric: "counter"},
+			err:   false,
+			name:  "time_series_metric for long",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "keyword", "time_series_metric": "gauge"},
+			err:  true,
+			name: "invalid config time_series_metric for keyword",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "double", "time_series_metric": "histogram"},
+			err:  true,
+			name: "invalid config unknown time_series_metric",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "keyword", "time_series_dimension": true},
+			field: Field{Name: "test", Type: "keyword", TimeSeriesDimension: &trueVar},
+			err:   false,
+			name:  "time_series_dimension for keyword",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "long", "time_series_dimension": true},
+			err:  true,
+			name: "invalid config time_series_dimension for long",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "text", "boost": 2.0},
+			field: Field{Name: "test", Type: "text", Boost: &boost},
+			err:   false,
+			name:  "boost for text",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "long", "boost": 2.0},
+			err:  true,
+			name: "invalid config boost for long",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "keyword", "boost": -1.0},
+			err:  true,
+			name: "invalid config negative boost",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "date", "ignore_malformed": true},
+			field: Field{Name: "test", Type: "date", IgnoreMalformed: &trueVar},
+			err:   false,
+			name:  "ignore_malformed for date",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "keyword", "ignore_malformed": true},
+			err:  true,
+			name: "invalid config ignore_malformed for keyword",
+		}, {
+			cfg: MapStr{"name": "test", "type": "group", "fields": []MapStr{
+				{"name": "message", "type": "text", "multi_fields": []MapStr{{"name": "keyword", "type": "keyword"}}},
+				{"name": "message.keyword", "type": "keyword"},
+			}},
+			err:  true,
+			name: "invalid config multi-field colliding with sibling",
+		}, {
+			cfg:   MapStr{"name": "test", "type": "half_float", "overrides": MapStr{"v2": MapStr{"type": "float"}}},
+			field: Field{Name: "test", Type: "half_float", Overrides: map[string]Field{"v2": {Type: "float"}}},
+			err:   false,
+			name:  "overrides",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "keyword", "overrides": MapStr{"latest": MapStr{"type": "text"}}},
+			err:  true,
+			name: "invalid config overrides with invalid version",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "keyword", "overrides": MapStr{"v5": MapStr{"type": "alias"}}},
+			err:  true,
+			name: "invalid config overrides with type not available in version",
+		}, {
+			cfg: MapStr{"name": "test", "type": "alias", "path": "host.name"},
+			// Path has no config tag, so it is unpacked from path as well
+			field: Field{Name: "test", Type: "alias", AliasPath: "host.name", Path: "host.name"},
+			err:   false,
+			name:  "alias with path",
+		}, {
+			cfg:  MapStr{"name": "test", "type": "alias"},
+			err:  true,
+			name: "invalid config alias without path",
 		},
 	}
 
@@ -247,3 +750,2215 @@ func TestFieldValidate(t *testing.T) {
 	}
 
 }
+
+func TestLoadFieldsDeprecatedTypes(t *testing.T) {
+	yml := `
+- key: base
+  fields:
+    - name: message
+      type: string
+      multi_fields:
+        - name: raw
+          type: string
+    - name: count
+      type: long
+`
+	fields, err := LoadFields(strings.NewReader(yml))
+	require.NoError(t, err)
+	assert.Equal(t, Fields{
+		Field{Name: "message", Type: "string", MultiFields: Fields{
+			Field{Name: "raw", Type: "string"},
+		}},
+		Field{Name: "count", Type: "long"},
+	}, fields)
+
+	fields, err = LoadFields(strings.NewReader(yml), NormalizeDeprecatedTypes())
+	require.NoError(t, err)
+	assert.Equal(t, Fields{
+		Field{Name: "message", Type: "keyword", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "count", Type: "long"},
+	}, fields)
+
+	_, err = LoadFields(strings.NewReader(yml), NormalizeDeprecatedTypes(), RejectDeprecatedTypes())
+	require.Error(t, err)
+	errs, ok := err.(*multierror.MultiError)
+	require.True(t, ok)
+	require.Len(t, errs.Errors, 2)
+	assert.EqualError(t, errs.Errors[0], "type 'string' of field 'message' is deprecated, use 'keyword' instead")
+	assert.EqualError(t, errs.Errors[1], "type 'string' of field 'message.raw' is deprecated, use 'keyword' instead")
+}
+
+func TestLoadFieldsYaml(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fields")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fields.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+- key: base
+  fields:
+    - name: message
+      type: string
+    - name: host
+      type: keyword
+    - name: count
+      type: long
+`), 0644))
+
+	fields, err := LoadFieldsYaml(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"message", "host", "count"}, fields.GetKeys())
+	assert.Equal(t, "string", fields[0].Type)
+
+	fields, err = LoadFieldsYaml(path, NormalizeDeprecatedTypes())
+	require.NoError(t, err)
+	assert.Equal(t, "keyword", fields[0].Type)
+
+	// Invalid fields are reported instead of loading an empty tree.
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+- key: base
+  fields:
+    - name: message
+      type: keyword
+    - name: count
+      type: long
+      analyzer: simple
+`), 0644))
+
+	_, err = LoadFieldsYaml(path)
+	assert.Error(t, err)
+}
+
+func TestFieldNormalizedType(t *testing.T) {
+	assert.Equal(t, "keyword", Field{Type: "string"}.NormalizedType())
+	assert.Equal(t, "long", Field{Type: "long"}.NormalizedType())
+	assert.Equal(t, "", Field{}.NormalizedType())
+}
+
+func TestFieldsDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new Fields
+		diff     FieldsDiff
+	}{
+		{
+			name: "no changes",
+			old:  Fields{Field{Name: "a", Type: "keyword"}},
+			new:  Fields{Field{Name: "a", Type: "keyword"}},
+			diff: FieldsDiff{},
+		},
+		{
+			name: "added and removed",
+			old:  Fields{Field{Name: "a"}, Field{Name: "b"}},
+			new:  Fields{Field{Name: "b"}, Field{Name: "c"}},
+			diff: FieldsDiff{Removed: []string{"a"}, Added: []string{"c"}},
+		},
+		{
+			name: "changed attributes",
+			old: Fields{
+				Field{Name: "a", Type: "object", ObjectType: "long", Dynamic: DynamicType{true}},
+				Field{Name: "b", Type: "scaled_float", ScalingFactor: 10},
+			},
+			new: Fields{
+				Field{Name: "a", Type: "object", ObjectType: "keyword", Dynamic: DynamicType{"strict"}},
+				Field{Name: "b", Type: "float", ScalingFactor: 100},
+			},
+			diff: FieldsDiff{Changed: []FieldChange{
+				{Key: "a", Attribute: "object_type", Old: "long", New: "keyword"},
+				{Key: "a", Attribute: "dynamic", Old: true, New: "strict"},
+				{Key: "b", Attribute: "type", Old: "scaled_float", New: "float"},
+				{Key: "b", Attribute: "scaling_factor", Old: 10.0, New: 100.0},
+			}},
+		},
+		{
+			name: "duplicate sibling groups",
+			old: Fields{
+				Field{Name: "a", Fields: Fields{Field{Name: "b"}}},
+				Field{Name: "a", Fields: Fields{Field{Name: "c"}}},
+			},
+			new: Fields{
+				Field{Name: "a", Fields: Fields{Field{Name: "b"}, Field{Name: "c", Type: "long"}}},
+			},
+			diff: FieldsDiff{Changed: []FieldChange{
+				{Key: "a.c", Attribute: "type", Old: "", New: "long"},
+			}},
+		},
+		{
+			name: "leaf changed to group",
+			old:  Fields{Field{Name: "a"}},
+			new:  Fields{Field{Name: "a", Type: "group", Fields: Fields{Field{Name: "b"}}}},
+			diff: FieldsDiff{Removed: []string{"a"}, Added: []string{"a.b"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.diff, test.old.Diff(test.new))
+		})
+	}
+}
+
+func TestFieldsMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     Fields
+		expected Fields
+		err      string
+	}{
+		{
+			name:     "disjoint fields",
+			a:        Fields{Field{Name: "a"}},
+			b:        Fields{Field{Name: "b"}},
+			expected: Fields{Field{Name: "a"}, Field{Name: "b"}},
+		},
+		{
+			name:     "identical definitions",
+			a:        Fields{Field{Name: "a", Type: "long"}},
+			b:        Fields{Field{Name: "a", Type: "long"}},
+			expected: Fields{Field{Name: "a", Type: "long"}},
+		},
+		{
+			name: "groups are merged",
+			a: Fields{
+				Field{Name: "test", Type: "group", Fields: Fields{Field{Name: "a"}}},
+			},
+			b: Fields{
+				Field{Name: "test", Type: "group", Fields: Fields{Field{Name: "a"}, Field{Name: "b"}}},
+			},
+			expected: Fields{
+				Field{Name: "test", Type: "group", Fields: Fields{Field{Name: "a"}, Field{Name: "b"}}},
+			},
+		},
+		{
+			name: "conflicting types",
+			a:    Fields{Field{Name: "test", Type: "group", Fields: Fields{Field{Name: "a", Type: "long"}}}},
+			b:    Fields{Field{Name: "test", Type: "group", Fields: Fields{Field{Name: "a", Type: "keyword"}}}},
+			err:  "field 'test.a' has conflicting types: 'long' and 'keyword'",
+		},
+		{
+			name: "conflicting object types",
+			a:    Fields{Field{Name: "a", Type: "object", ObjectType: "long"}},
+			b:    Fields{Field{Name: "a", Type: "object", ObjectType: "keyword"}},
+			err:  "field 'a' has conflicting object types: 'long' and 'keyword'",
+		},
+		{
+			name: "leaf and group",
+			a:    Fields{Field{Name: "a"}},
+			b:    Fields{Field{Name: "a", Fields: Fields{Field{Name: "b"}}}},
+			err:  "field 'a' is defined as both a leaf and a group",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := test.a.GetKeys()
+
+			merged, err := test.a.Merge(test.b)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, merged)
+			assert.Equal(t, original, test.a.GetKeys())
+		})
+	}
+}
+
+func TestFieldsConflictingKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    Fields
+		conflicts map[string][]string
+	}{
+		{
+			name: "no duplicates",
+			fields: Fields{
+				Field{Name: "source", Fields: Fields{Field{Name: "ip", Type: "ip"}}},
+			},
+			conflicts: map[string][]string{},
+		},
+		{
+			name: "compatible duplicates",
+			fields: Fields{
+				Field{Name: "source", Fields: Fields{Field{Name: "ip", Type: "ip"}}},
+				Field{Name: "source", Fields: Fields{Field{Name: "ip", Type: "ip"}, Field{Name: "name"}}},
+				Field{Name: "source.name", Type: "keyword"},
+			},
+			conflicts: map[string][]string{},
+		},
+		{
+			name: "conflicting types",
+			fields: Fields{
+				Field{Name: "source", Fields: Fields{Field{Name: "ip", Type: "ip"}}},
+				Field{Name: "source", Fields: Fields{Field{Name: "ip", Type: "keyword"}}},
+				Field{Name: "source", Fields: Fields{Field{Name: "ip", Type: "ip"}}},
+				Field{Name: "source", Fields: Fields{Field{Name: "ip", Type: "text"}}},
+			},
+			conflicts: map[string][]string{"source.ip": {"ip", "keyword", "text"}},
+		},
+		{
+			name: "aliases are followed",
+			fields: Fields{
+				Field{Name: "client", Fields: Fields{Field{Name: "ip", Type: "ip"}}},
+				Field{Name: "source", Fields: Fields{Field{Name: "ip", Type: "alias", AliasPath: "client.ip"}}},
+				Field{Name: "source", Fields: Fields{Field{Name: "ip", Type: "ip"}}},
+				Field{Name: "dest", Fields: Fields{Field{Name: "ip", Type: "alias", AliasPath: "client.ip"}}},
+				Field{Name: "dest", Fields: Fields{Field{Name: "ip", Type: "long"}}},
+			},
+			conflicts: map[string][]string{"dest.ip": {"ip", "long"}},
+		},
+		{
+			name: "alias cycles",
+			fields: Fields{
+				Field{Name: "a", Type: "alias", AliasPath: "b"},
+				Field{Name: "b", Type: "alias", AliasPath: "a"},
+				Field{Name: "a", Type: "keyword"},
+			},
+			conflicts: map[string][]string{"a": {"alias", "keyword"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.conflicts, test.fields.ConflictingKeys())
+		})
+	}
+}
+
+func TestFieldsValidateMultiFieldCollision(t *testing.T) {
+	fields := Fields{
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "keyword", Type: "keyword"},
+		}},
+		Field{Name: "message.keyword", Type: "keyword"},
+	}
+	assert.EqualError(t, fields.Validate(), "1 error: multi-field 'keyword' of field 'message' collides with field 'message.keyword'")
+
+	nested := Fields{
+		Field{Name: "log", Type: "group", Fields: fields},
+	}
+	assert.EqualError(t, nested.Validate(), "1 error: log: multi-field 'keyword' of field 'message' collides with field 'message.keyword'")
+
+	cfg, err := yaml.NewConfig([]byte(`
+- key: log
+  title: Log
+  fields:
+  - name: message
+    type: text
+    multi_fields:
+    - name: keyword
+      type: keyword
+  - name: message.keyword
+    type: keyword
+`))
+	require.NoError(t, err)
+	var unpacked Fields
+	assert.Error(t, cfg.Unpack(&unpacked))
+}
+
+func TestFieldsDetectLeafGroupConflicts(t *testing.T) {
+	falseVar := false
+
+	tests := []struct {
+		name      string
+		fields    Fields
+		conflicts []string
+	}{
+		{
+			name: "no conflicts",
+			fields: Fields{
+				Field{Name: "process", Type: "group", Fields: Fields{
+					Field{Name: "name"},
+				}},
+				Field{Name: "process", Type: "group", Fields: Fields{
+					Field{Name: "pid", Type: "long"},
+				}},
+				Field{Name: "message", Type: "text", MultiFields: Fields{
+					Field{Name: "raw", Type: "keyword"},
+				}},
+				Field{Name: "labels", Type: "object"},
+				Field{Name: "labels.env"},
+				Field{Name: "location", Type: "geo_point", Fields: Fields{
+					Field{Name: "lat"},
+				}},
+			},
+		},
+		{
+			name: "leaf and group",
+			fields: Fields{
+				Field{Name: "process"},
+				Field{Name: "process", Type: "group", Fields: Fields{
+					Field{Name: "name"},
+				}},
+			},
+			conflicts: []string{"process"},
+		},
+		{
+			name: "dotted names",
+			fields: Fields{
+				Field{Name: "host", Type: "group", Fields: Fields{
+					Field{Name: "os", Type: "keyword"},
+				}},
+				Field{Name: "host.os.name"},
+				Field{Name: "message", Type: "text"},
+				Field{Name: "message", Type: "group", Fields: Fields{
+					Field{Name: "raw"},
+				}},
+			},
+			conflicts: []string{"host.os", "message"},
+		},
+		{
+			name: "disabled group",
+			fields: Fields{
+				Field{Name: "raw", Type: "group", Enabled: &falseVar, Fields: Fields{
+					Field{Name: "data"},
+				}},
+				Field{Name: "raw", Fields: Fields{
+					Field{Name: "data", Type: "group", Fields: Fields{
+						Field{Name: "id"},
+					}},
+				}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.conflicts, test.fields.DetectLeafGroupConflicts())
+		})
+	}
+}
+
+func TestFieldsValidateDescriptions(t *testing.T) {
+	fields := Fields{
+		Field{Name: "message", Type: "text", Description: "The log message.", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Description: "  \n"},
+			Field{Name: "ip", Type: "ip", Description: "IP"},
+			Field{Name: "os", Type: "group", Description: "Operating system.", Fields: Fields{
+				Field{Name: "family", Description: "OS family, e.g. debian."},
+			}},
+		}},
+		Field{Name: "hostname", Type: "alias", AliasPath: "host.name"},
+		Field{Name: "labels", Type: "object"},
+	}
+
+	assert.Equal(t, []string{"host.name", "labels"}, fields.ValidateDescriptions(0))
+	assert.Equal(t, []string{"host.name", "host.ip", "labels"}, fields.ValidateDescriptions(5))
+	assert.Equal(t, []string{"message", "host.name", "host.ip", "labels"}, fields.ValidateDescriptions(20))
+}
+
+func TestFieldsContains(t *testing.T) {
+	old := Fields{
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name"},
+			Field{Name: "uptime", Type: "long"},
+		}},
+		Field{Name: "hostname", Type: "alias", AliasPath: "host.name"},
+		Field{Name: "source", Type: "alias", AliasPath: "host.name"},
+	}
+
+	current := Fields{
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "uptime", Type: "long"},
+			Field{Name: "os", Type: "keyword"},
+		}},
+		Field{Name: "hostname", Type: "alias", AliasPath: "host.name"},
+		Field{Name: "source", Type: "keyword"},
+	}
+
+	contained, keys := current.Contains(old)
+	assert.True(t, contained)
+	assert.Empty(t, keys)
+
+	changed := Fields{
+		Field{Name: "message", Type: "text"},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "uptime", Type: "integer"},
+		}},
+		Field{Name: "hostname", Type: "alias", AliasPath: "host.uptime"},
+		Field{Name: "source", Type: "keyword"},
+	}
+
+	contained, keys = changed.Contains(old)
+	assert.False(t, contained)
+	assert.Equal(t, []string{"message.raw", "host.uptime", "hostname"}, keys)
+}
+
+func TestFieldsResolveAlias(t *testing.T) {
+	fields := Fields{
+		Field{Name: "client", Fields: Fields{
+			Field{Name: "ip", Type: "ip"},
+			Field{Name: "address", Type: "alias", AliasPath: "client.ip"},
+		}},
+		Field{Name: "source", Fields: Fields{
+			Field{Name: "ip", Type: "alias", AliasPath: "client.address"},
+			Field{Name: "missing", Type: "alias", AliasPath: "client.port"},
+		}},
+		Field{Name: "a", Type: "alias", AliasPath: "b"},
+		Field{Name: "b", Type: "alias", AliasPath: "c"},
+		Field{Name: "c", Type: "alias", AliasPath: "a"},
+	}
+
+	tests := []struct {
+		key      string
+		resolved string
+		err      string
+	}{
+		{key: "client.ip", resolved: "client.ip"},
+		{key: "client.address", resolved: "client.ip"},
+		{key: "source.ip", resolved: "client.ip"},
+		{key: "source.missing", err: "field 'client.port' not found"},
+		{key: "source.port", err: "field 'source.port' not found"},
+		{key: "a", err: "alias 'c' is part of a cycle"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			resolved, err := fields.ResolveAlias(test.key)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.resolved, resolved)
+		})
+	}
+}
+
+func TestFieldsFilterByType(t *testing.T) {
+	enabled := true
+	fields := Fields{
+		Field{Name: "a", Type: "keyword"},
+		Field{Name: "b", Type: "long"},
+		Field{Name: "c", Type: "group", Description: "group c", Enabled: &enabled, Fields: Fields{
+			Field{Name: "d", Type: "text", Index: &enabled},
+			Field{Name: "e", Type: "ip"},
+			Field{Name: "f", Type: "group", Fields: Fields{
+				Field{Name: "g", Type: "long"},
+			}},
+		}},
+		Field{Name: "h", Type: "group", Fields: Fields{
+			Field{Name: "i", Type: "float"},
+		}},
+	}
+
+	filtered := fields.FilterByType("keyword", "text")
+	assert.Equal(t, Fields{
+		Field{Name: "a", Type: "keyword"},
+		Field{Name: "c", Type: "group", Description: "group c", Enabled: &enabled, Fields: Fields{
+			Field{Name: "d", Type: "text", Index: &enabled},
+		}},
+	}, filtered)
+
+	// The original tree is left untouched.
+	*filtered[1].Fields[0].Index = false
+	filtered[1].Fields[0].Name = "changed"
+	assert.True(t, *fields[2].Fields[0].Index)
+	assert.Equal(t, []string{"a", "b", "c.d", "c.e", "c.f.g", "h.i"}, fields.GetKeys())
+
+	assert.Nil(t, fields.FilterByType("boolean"))
+
+	untyped := Fields{
+		Field{Name: "name"},
+		Field{Name: "count", Type: "long"},
+		Field{Name: "total", Type: "alias", AliasPath: "count"},
+	}
+	assert.Equal(t, Fields{Field{Name: "name"}}, untyped.FilterByType("keyword"))
+	assert.Equal(t, untyped[1:], untyped.FilterByType("long"))
+	assert.Equal(t, untyped[2:], untyped.FilterByType("alias"))
+}
+
+func TestFieldsFilterEnabled(t *testing.T) {
+	fields := Fields{
+		Field{Name: "system", Type: "group", Fields: Fields{
+			Field{Name: "cpu", Type: "group", Fields: Fields{
+				Field{Name: "total.pct", Type: "scaled_float"},
+			}},
+			Field{Name: "process", Type: "group", Fields: Fields{
+				Field{Name: "name", Type: "keyword"},
+				Field{Name: "cmdline", Type: "keyword", MultiFields: Fields{
+					Field{Name: "text", Type: "text"},
+				}},
+			}},
+		}},
+		Field{Name: "message", Type: "text"},
+	}
+
+	var visited []string
+	filtered := fields.FilterEnabled(func(path string) bool {
+		visited = append(visited, path)
+		return path != "system.process" && path != "message"
+	})
+	assert.Equal(t, Fields{
+		Field{Name: "system", Type: "group", Fields: Fields{
+			Field{Name: "cpu", Type: "group", Fields: Fields{
+				Field{Name: "total.pct", Type: "scaled_float"},
+			}},
+		}},
+	}, filtered)
+	assert.Equal(t, []string{"system", "system.cpu", "system.cpu.total.pct", "system.process", "message"}, visited)
+
+	filtered = fields.FilterEnabled(func(path string) bool {
+		return path != "system.cpu.total.pct" && path != "system.process.cmdline.text"
+	})
+	assert.Equal(t, []string{"system.process.name", "system.process.cmdline", "message"}, filtered.GetKeys())
+
+	assert.Nil(t, fields.FilterEnabled(func(string) bool { return false }))
+	assert.Equal(t, fields, fields.FilterEnabled(func(string) bool { return true }))
+}
+
+func TestFieldsCanonicalize(t *testing.T) {
+	fields := Fields{
+		Field{Name: "c", Type: "long"},
+		Field{Name: "a", Type: "group", Description: "first", Fields: Fields{
+			Field{Name: "c"},
+			Field{Name: "b"},
+		}},
+		Field{Name: "b", Type: "keyword"},
+		Field{Name: "a", Type: "group", Description: "second", Fields: Fields{
+			Field{Name: "a"},
+			Field{Name: "b"},
+		}},
+		Field{Name: "c", Type: "keyword"},
+		Field{Name: "c", Type: "long"},
+	}
+
+	expected := Fields{
+		Field{Name: "a", Type: "group", Description: "first", Fields: Fields{
+			Field{Name: "a"},
+			Field{Name: "b"},
+			Field{Name: "c"},
+		}},
+		Field{Name: "b", Type: "keyword"},
+		Field{Name: "c", Type: "long"},
+		Field{Name: "c", Type: "keyword"},
+	}
+
+	canonical := fields.Canonicalize()
+	assert.Equal(t, expected, canonical)
+	assert.Equal(t, canonical, canonical.Canonicalize())
+
+	// The original tree is left untouched.
+	assert.Equal(t, []string{"c", "a.c", "a.b", "b", "a.a", "a.b", "c", "c"}, fields.GetKeys())
+}
+
+func TestFieldsValidate(t *testing.T) {
+	fields := Fields{
+		Field{Name: "a", Type: "scaled_float", ScalingFactor: 10},
+		Field{Name: "b", Type: "group", Fields: Fields{
+			Field{Name: "c", Type: "float", ScalingFactor: 10},
+			Field{Name: "d", Type: "object", ObjectType: "long", ObjectTypeParams: []ObjectTypeCfg{{ObjectType: "keyword"}}},
+		}},
+		Field{Name: "e", Type: "text", MultiFields: Fields{
+			Field{Name: "f", Type: "keyword", ScalingFactor: 10},
+		}},
+	}
+
+	err := fields.Validate()
+	require.Error(t, err)
+	errs, ok := err.(*multierror.MultiError)
+	require.True(t, ok)
+	require.Len(t, errs.Errors, 3)
+	assert.EqualError(t, errs.Errors[0], "b.c: scaling_factor is set for field 'c' but it is not of type scaled_float")
+	assert.EqualError(t, errs.Errors[1], "b.d: mixing top level objectType configuration with array of object type configurations is forbidden")
+	assert.EqualError(t, errs.Errors[2], "e.f: scaling_factor is set for field 'f' but it is not of type scaled_float")
+
+	assert.NoError(t, fields[:1].Validate())
+}
+
+func TestFieldsValidateDuplicateKeys(t *testing.T) {
+	fields := Fields{
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name"},
+			Field{Name: "ip", Type: "ip"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "os", Type: "keyword"},
+		}},
+		Field{Name: "host.ip", Type: "ip"},
+	}
+	assert.NoError(t, fields.Validate())
+
+	fields = append(fields,
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "ip", Type: "keyword"},
+		}},
+		Field{Name: "metrics", Type: "object", ObjectType: "long"},
+		Field{Name: "metrics", Type: "object", ObjectType: "double"},
+	)
+	err := fields.Validate()
+	require.Error(t, err)
+	errs, ok := err.(*multierror.MultiError)
+	require.True(t, ok)
+	require.Len(t, errs.Errors, 2)
+	assert.EqualError(t, errs.Errors[0], "field 'host.ip' is defined multiple times with conflicting type: 'ip' and 'keyword'")
+	assert.EqualError(t, errs.Errors[1], "field 'metrics' is defined multiple times with conflicting object_type: 'long' and 'double'")
+}
+
+func TestFieldsSelect(t *testing.T) {
+	fields := Fields{
+		Field{Name: "system", Type: "group", Fields: Fields{
+			Field{Name: "process", Type: "group", Fields: Fields{
+				Field{Name: "name"},
+				Field{Name: "cpu", Type: "group", Fields: Fields{
+					Field{Name: "pct", Type: "scaled_float"},
+				}},
+			}},
+			Field{Name: "memory", Type: "long"},
+		}},
+		Field{Name: "source", Type: "group", Fields: Fields{
+			Field{Name: "ip", Type: "ip"},
+			Field{Name: "port", Type: "long"},
+		}},
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "keyword", Type: "keyword"},
+		}},
+	}
+
+	tests := []struct {
+		pattern string
+		keys    []string
+	}{
+		{pattern: "system.process.*", keys: []string{"system.process.name", "system.process.cpu.pct"}},
+		{pattern: "*.ip", keys: []string{"source.ip"}},
+		{pattern: "source.port", keys: []string{"source.port"}},
+		{pattern: "*.keyword", keys: []string{"message", "message.keyword"}},
+		{pattern: "system", keys: nil},
+		{pattern: "unknown.*", keys: nil},
+		{pattern: "[", keys: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			selected := fields.Select(test.pattern)
+			assert.Equal(t, test.keys, selected.GetKeys())
+		})
+	}
+
+	selected := fields.Select("system.process.cpu.*")
+	assert.Equal(t, Fields{
+		Field{Name: "system", Type: "group", Fields: Fields{
+			Field{Name: "process", Type: "group", Fields: Fields{
+				Field{Name: "cpu", Type: "group", Fields: Fields{
+					Field{Name: "pct", Type: "scaled_float"},
+				}},
+			}},
+		}},
+	}, selected)
+}
+
+func TestFieldObjectTypeFor(t *testing.T) {
+	tests := []struct {
+		name        string
+		field       Field
+		mappingType string
+		cfg         ObjectTypeCfg
+		found       bool
+	}{
+		{
+			name:        "top level object type",
+			field:       Field{Type: "object", ObjectType: "long"},
+			mappingType: "long",
+			cfg:         ObjectTypeCfg{ObjectType: "long"},
+			found:       true,
+		},
+		{
+			name:        "top level object type not matching",
+			field:       Field{Type: "object", ObjectType: "long"},
+			mappingType: "string",
+			found:       false,
+		},
+		{
+			name:        "top level scaled_float matches all types",
+			field:       Field{Type: "object", ObjectType: "scaled_float", ScalingFactor: 10},
+			mappingType: "long",
+			cfg:         ObjectTypeCfg{ObjectType: "scaled_float", ScalingFactor: 10},
+			found:       true,
+		},
+		{
+			name: "params with explicit mapping type",
+			field: Field{Type: "object", ObjectTypeParams: []ObjectTypeCfg{
+				{ObjectType: "scaled_float", ObjectTypeMappingType: "float", ScalingFactor: 100},
+				{ObjectType: "long", ObjectTypeMappingType: "long"},
+			}},
+			mappingType: "long",
+			cfg:         ObjectTypeCfg{ObjectType: "long", ObjectTypeMappingType: "long"},
+			found:       true,
+		},
+		{
+			name: "params with default mapping type",
+			field: Field{Type: "object", ObjectTypeParams: []ObjectTypeCfg{
+				{ObjectType: "scaled_float", ScalingFactor: 100},
+				{ObjectType: "keyword"},
+			}},
+			mappingType: "string",
+			cfg:         ObjectTypeCfg{ObjectType: "keyword"},
+			found:       true,
+		},
+		{
+			name: "params falling back to wildcard",
+			field: Field{Type: "object", ObjectTypeParams: []ObjectTypeCfg{
+				{ObjectType: "keyword"},
+				{ObjectType: "scaled_float", ScalingFactor: 100},
+			}},
+			mappingType: "double",
+			cfg:         ObjectTypeCfg{ObjectType: "scaled_float", ScalingFactor: 100},
+			found:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, found := test.field.ObjectTypeFor(test.mappingType)
+			assert.Equal(t, test.found, found)
+			assert.Equal(t, test.cfg, cfg)
+		})
+	}
+}
+
+func TestFieldsTypeMap(t *testing.T) {
+	falseVar := false
+	fields := Fields{
+		Field{Name: "a", Fields: Fields{
+			Field{Name: "b", Type: "long"},
+		}},
+		Field{Name: "a", Fields: Fields{
+			Field{Name: "c"},
+			Field{Name: "b", Type: "keyword"},
+		}},
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "keyword", Type: "keyword"},
+		}},
+		Field{Name: "d", Type: "alias", AliasPath: "a.b"},
+		Field{Name: "e", Type: "alias", AliasPath: "missing"},
+		Field{Name: "disabled", Enabled: &falseVar, Fields: Fields{
+			Field{Name: "f", Type: "long"},
+		}},
+	}
+
+	assert.Equal(t, map[string]string{
+		"a.b":             "long",
+		"a.c":             "keyword",
+		"message":         "text",
+		"message.keyword": "keyword",
+		"d":               "long",
+		"e":               "alias",
+		"disabled":        "object",
+	}, fields.TypeMap())
+}
+
+func TestFieldsAllTypes(t *testing.T) {
+	falseVar := false
+	fields := Fields{
+		Field{Name: "a", Fields: Fields{
+			Field{Name: "b", Type: "long"},
+			Field{Name: "c"},
+		}},
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "keyword", Type: "keyword"},
+			Field{Name: "english", Type: "text"},
+		}},
+		Field{Name: "d", Type: "alias", AliasPath: "a.b"},
+		Field{Name: "e", Type: "ip"},
+		Field{Name: "disabled", Enabled: &falseVar, Fields: Fields{
+			Field{Name: "f", Type: "geo_shape"},
+		}},
+	}
+
+	assert.Equal(t, []string{"ip", "keyword", "long", "text"}, fields.AllTypes())
+	assert.Empty(t, Fields{}.AllTypes())
+}
+
+func TestFieldsNestedInThemselves(t *testing.T) {
+	fields := make(Fields, 2)
+	fields[0] = Field{Name: "a", Type: "group", Fields: fields}
+	fields[1] = Field{Name: "b", Type: "group", Fields: Fields{
+		Field{Name: "c", MultiFields: fields[1:]},
+	}}
+
+	assert.Equal(t, []string{"a.b.c", "b.c"}, fields.GetKeys())
+	assert.Equal(t, []string{"a", "a.b", "a.b.c", "b", "b.c"}, fields.GetKeysWithGroups())
+	assert.False(t, fields.HasKey("a.a.a.a.a"))
+	assert.Equal(t, map[string]string{"a.b.c": "keyword", "b.c": "keyword"}, fields.TypeMap())
+	assert.Equal(t, []string{"a.b.c", "b.c"}, fields.Canonicalize().GetKeys())
+	assert.Equal(t, []string{"a.b.c", "b.c"}, fields.Select("*").GetKeys())
+
+	err := fields.Validate()
+	require.Error(t, err)
+	errs, ok := err.(*multierror.MultiError)
+	require.True(t, ok)
+	require.Len(t, errs.Errors, 3)
+	assert.EqualError(t, errs.Errors[0], "a.a: field is nested in itself")
+	assert.EqualError(t, errs.Errors[1], "a.b.c.b: field is nested in itself")
+	assert.EqualError(t, errs.Errors[2], "b.c.b: field is nested in itself")
+
+	target := Fields{Field{Name: "a", Type: "group", Fields: Fields{
+		Field{Name: "a", Type: "group", Fields: Fields{Field{Name: "x"}}},
+	}}}
+	_, err = target.Merge(fields)
+	assert.EqualError(t, err, "field 'a.a' is nested in itself")
+}
+
+func TestFieldNormsDocValuesYaml(t *testing.T) {
+	falseVar := false
+	trueVar := true
+
+	tests := []struct {
+		input     string
+		norms     *bool
+		docValues *bool
+	}{
+		{input: "name: test", norms: nil, docValues: nil},
+		{input: "name: test\nnorms: false\ndoc_values: false", norms: &falseVar, docValues: &falseVar},
+		{input: "name: test\nnorms: true\ndoc_values: true", norms: &trueVar, docValues: &trueVar},
+	}
+
+	for _, test := range tests {
+		cfg, err := yaml.NewConfig([]byte(test.input))
+		require.NoError(t, err)
+		var f Field
+		require.NoError(t, cfg.Unpack(&f))
+		assert.Equal(t, test.norms, f.Norms)
+		assert.Equal(t, test.docValues, f.DocValues)
+	}
+}
+
+func TestFieldsDefaultFields(t *testing.T) {
+	falseVar := false
+	trueVar := true
+	fields := Fields{
+		Field{Name: "a"},
+		Field{Name: "b", Type: "keyword", DefaultField: &trueVar},
+		Field{Name: "c", Type: "text", DefaultField: &falseVar},
+		Field{Name: "d", Type: "keyword", Index: &falseVar},
+		Field{Name: "e", Type: "long"},
+		Field{Name: "f", Type: "group", Fields: Fields{
+			Field{Name: "g", Type: "text", MultiFields: Fields{
+				Field{Name: "keyword", Type: "keyword"},
+			}},
+		}},
+	}
+
+	assert.Equal(t, []string{"a", "b", "f.g", "f.g.keyword"}, fields.DefaultFields())
+}
+
+func TestFieldsCount(t *testing.T) {
+	fields := Fields{
+		Field{Name: "a"},
+		Field{Name: "b", Type: "group", Fields: Fields{
+			Field{Name: "c", Type: "long"},
+			Field{Name: "d", Type: "text", MultiFields: Fields{
+				Field{Name: "keyword", Type: "keyword"},
+			}},
+		}},
+		Field{Name: "b", Type: "group", Fields: Fields{
+			Field{Name: "e", Type: "long"},
+		}},
+		Field{Name: "f", Type: "alias", AliasPath: "a"},
+	}
+
+	assert.Equal(t, len(fields.GetKeys()), fields.Count())
+	assert.Equal(t, 6, fields.Count())
+	assert.Equal(t, map[string]int{"keyword": 2, "long": 2, "text": 1, "alias": 1}, fields.CountByType())
+	assert.Equal(t, 0, Fields{}.Count())
+}
+
+func TestFieldsGroupByNamespace(t *testing.T) {
+	fields := Fields{
+		Field{Name: "@timestamp", Type: "date"},
+		Field{Name: "system", Type: "group", Fields: Fields{
+			Field{Name: "cpu.pct", Type: "scaled_float"},
+		}},
+		Field{Name: "nginx", Type: "group", Fields: Fields{
+			Field{Name: "access.url"},
+		}},
+		Field{Name: "system.memory", Type: "group", Fields: Fields{
+			Field{Name: "total", Type: "long"},
+		}},
+		Field{Name: "message", Type: "text"},
+	}
+
+	namespaces := fields.GroupByNamespace()
+	assert.Equal(t, map[string]Fields{
+		RootNamespace: {
+			Field{Name: "@timestamp", Type: "date"},
+			Field{Name: "message", Type: "text"},
+		},
+		"system": {
+			Field{Name: "system", Type: "group", Fields: Fields{
+				Field{Name: "cpu.pct", Type: "scaled_float"},
+			}},
+			Field{Name: "system.memory", Type: "group", Fields: Fields{
+				Field{Name: "total", Type: "long"},
+			}},
+		},
+		"nginx": {
+			Field{Name: "nginx", Type: "group", Fields: Fields{
+				Field{Name: "access.url"},
+			}},
+		},
+	}, namespaces)
+	assert.Equal(t, []string{"system.memory.total"}, namespaces["system"].GetKeys()[1:])
+
+	namespaces["nginx"][0].Fields[0].Name = "modified"
+	assert.Equal(t, "access.url", fields[2].Fields[0].Name)
+}
+
+func TestFieldsSubtree(t *testing.T) {
+	fields := Fields{
+		Field{Name: "system", Type: "group", Fields: Fields{
+			Field{Name: "process", Type: "group", Fields: Fields{
+				Field{Name: "name"},
+				Field{Name: "cpu", Type: "group", Fields: Fields{
+					Field{Name: "pct", Type: "scaled_float"},
+				}},
+			}},
+		}},
+		Field{Name: "system", Type: "group", Fields: Fields{
+			Field{Name: "process", Type: "group", Fields: Fields{
+				Field{Name: "pid", Type: "long"},
+			}},
+			Field{Name: "load.norm", Type: "group", Fields: Fields{
+				Field{Name: "1", Type: "scaled_float"},
+			}},
+		}},
+	}
+
+	tests := []struct {
+		key   string
+		keys  []string
+		found bool
+	}{
+		{key: "system", keys: []string{"process.name", "process.cpu.pct", "process.pid", "load.norm.1"}, found: true},
+		{key: "system.process", keys: []string{"name", "cpu.pct", "pid"}, found: true},
+		{key: "system.process.cpu", keys: []string{"pct"}, found: true},
+		{key: "system.load.norm", keys: []string{"1"}, found: true},
+		{key: "system.process.name", found: false},
+		{key: "system.memory", found: false},
+		{key: "sys", found: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			subtree, found := fields.Subtree(test.key)
+			assert.Equal(t, test.found, found)
+			assert.Equal(t, test.keys, subtree.GetKeys())
+		})
+	}
+
+	// The subtree is a copy of the original fields.
+	subtree, _ := fields.Subtree("system.process")
+	subtree[0].Name = "changed"
+	assert.Equal(t, "name", fields[0].Fields[0].Fields[0].Name)
+}
+
+func TestFieldEqual(t *testing.T) {
+	base := Field{
+		Name: "a", Type: "group", Dynamic: DynamicType{true},
+		Fields: Fields{
+			Field{Name: "b", Type: "long"},
+			Field{Name: "c", Type: "object", ObjectTypeParams: []ObjectTypeCfg{
+				{ObjectType: "scaled_float", ScalingFactor: 10},
+				{ObjectType: "keyword"},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		other Field
+		equal bool
+	}{
+		{
+			name:  "identical",
+			other: base,
+			equal: true,
+		},
+		{
+			name: "nested fields in different order",
+			other: Field{
+				Name: "a", Type: "group", Dynamic: DynamicType{true},
+				Fields: Fields{
+					Field{Name: "c", Type: "object", ObjectTypeParams: []ObjectTypeCfg{
+						{ObjectType: "scaled_float", ScalingFactor: 10},
+						{ObjectType: "keyword"},
+					}},
+					Field{Name: "b", Type: "long"},
+				},
+			},
+			equal: true,
+		},
+		{
+			name: "description is ignored",
+			other: Field{
+				Name: "a", Type: "group", Dynamic: DynamicType{true}, Description: "other",
+				Fields: base.Fields,
+			},
+			equal: true,
+		},
+		{
+			name:  "different dynamic",
+			other: Field{Name: "a", Type: "group", Dynamic: DynamicType{"strict"}, Fields: base.Fields},
+			equal: false,
+		},
+		{
+			name: "different nested type",
+			other: Field{
+				Name: "a", Type: "group", Dynamic: DynamicType{true},
+				Fields: Fields{
+					Field{Name: "b", Type: "keyword"},
+					base.Fields[1],
+				},
+			},
+			equal: false,
+		},
+		{
+			name: "different object type params",
+			other: Field{
+				Name: "a", Type: "group", Dynamic: DynamicType{true},
+				Fields: Fields{
+					base.Fields[0],
+					Field{Name: "c", Type: "object", ObjectTypeParams: []ObjectTypeCfg{
+						{ObjectType: "scaled_float", ScalingFactor: 100},
+						{ObjectType: "keyword"},
+					}},
+				},
+			},
+			equal: false,
+		},
+		{
+			name:  "missing nested field",
+			other: Field{Name: "a", Type: "group", Dynamic: DynamicType{true}, Fields: base.Fields[:1]},
+			equal: false,
+		},
+		{
+			name: "different nested ignore_above",
+			other: Field{
+				Name: "a", Type: "group", Dynamic: DynamicType{true},
+				Fields: Fields{
+					Field{Name: "b", Type: "long", IgnoreAbove: 10},
+					base.Fields[1],
+				},
+			},
+			equal: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.equal, base.Equal(test.other))
+			assert.Equal(t, test.equal, test.other.Equal(base))
+
+			baseFingerprint := Fields{base}.Fingerprint()
+			otherFingerprint := Fields{test.other}.Fingerprint()
+			if test.equal {
+				assert.Equal(t, baseFingerprint, otherFingerprint)
+			} else {
+				assert.NotEqual(t, baseFingerprint, otherFingerprint)
+			}
+		})
+	}
+
+	conflicting := Field{Name: "a", Fields: Fields{
+		Field{Name: "x", Type: "keyword"},
+		Field{Name: "x", Type: "long"},
+	}}
+	swapped := Field{Name: "a", Fields: Fields{
+		Field{Name: "x", Type: "long"},
+		Field{Name: "x", Type: "keyword"},
+	}}
+	assert.True(t, conflicting.Equal(swapped))
+	assert.False(t, Field{Name: "x", Type: "keyword", IgnoreAbove: 10}.Equal(Field{Name: "x", Type: "keyword"}))
+}
+
+func TestFieldsNested(t *testing.T) {
+	fields := Fields{
+		Field{Name: "a", Type: "keyword"},
+		Field{Name: "users", Type: "nested", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "address", Type: "group", Fields: Fields{
+				Field{Name: "city", Type: "keyword"},
+			}},
+			Field{Name: "devices", Type: "nested", Fields: Fields{
+				Field{Name: "id", Type: "keyword"},
+			}},
+		}},
+		Field{Name: "b", Type: "group", Fields: Fields{
+			Field{Name: "tags", Type: "nested"},
+		}},
+	}
+
+	assert.Equal(t, []string{"users", "users.devices", "b.tags"}, fields.NestedPaths())
+	assert.Equal(t, map[string]string{
+		"users.name":         "users",
+		"users.address.city": "users",
+		"users.devices.id":   "users.devices",
+	}, fields.NestedParents())
+	assert.Equal(t, []string{"a", "users.name", "users.address.city", "users.devices.id", "b.tags"}, fields.GetKeys())
+}
+
+func TestFieldsWalk(t *testing.T) {
+	fields := Fields{
+		Field{Name: "a", Type: "group", Fields: Fields{
+			Field{Name: "b", Type: "keyword", MultiFields: Fields{
+				Field{Name: "text", Type: "text"},
+			}},
+			Field{Name: "c", Type: "long"},
+		}},
+		Field{Name: "d", Type: "keyword"},
+	}
+
+	t.Run("visits all fields", func(t *testing.T) {
+		var keys, types []string
+		err := fields.Walk(func(key string, field Field) error {
+			keys = append(keys, key)
+			types = append(types, field.Type)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "a.b", "a.b.text", "a.c", "d"}, keys)
+		assert.Equal(t, []string{"group", "keyword", "text", "long", "keyword"}, types)
+	})
+
+	t.Run("stops on first error", func(t *testing.T) {
+		expected := errors.New("stop")
+		var keys []string
+		err := fields.Walk(func(key string, field Field) error {
+			keys = append(keys, key)
+			if field.Type == "text" {
+				return expected
+			}
+			return nil
+		})
+		assert.Equal(t, expected, err)
+		assert.Equal(t, []string{"a", "a.b", "a.b.text"}, keys)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		cyclic := Fields{Field{Name: "a", Type: "group"}}
+		cyclic[0].Fields = cyclic
+
+		var keys []string
+		err := cyclic.Walk(func(key string, field Field) error {
+			keys = append(keys, key)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, keys)
+	})
+}
+
+func TestLoadFields(t *testing.T) {
+	fields, err := LoadFields(strings.NewReader(`
+- key: base
+  title: Base
+  fields:
+    - name: host
+      type: group
+      fields:
+        - name: name
+          type: keyword
+    - name: hostname
+      type: alias
+      path: host.name
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"host.name", "hostname"}, fields.GetKeys())
+
+	_, err = LoadFields(strings.NewReader(`
+- key: base
+  fields:
+    - name: count
+      type: long
+      analyzer: simple
+`))
+	assert.Error(t, err)
+
+	_, err = LoadFields(strings.NewReader(`
+- key: base
+  fields:
+    - name: message
+      type: text
+      copy_to: all
+`))
+	assert.Error(t, err)
+
+	_, err = LoadFields(strings.NewReader("- key: [base"))
+	assert.Error(t, err)
+
+	_, err = LoadFields(iotest.TimeoutReader(strings.NewReader("- key: base")))
+	assert.Error(t, err)
+}
+
+func TestLoadFieldsFromGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fields")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.yml": `
+- key: a
+  title: a
+  fields:
+    - name: host
+      type: group
+      fields:
+        - name: name
+          type: keyword
+`,
+		"b.yml": `
+- key: b
+  title: b
+  fields:
+    - name: host
+      type: group
+      fields:
+        - name: ip
+          type: ip
+    - name: message
+      type: text
+`,
+		"c.yml": `
+- key: c
+  title: c
+  fields:
+    - name: host
+      type: group
+      fields:
+        - name: ip
+          type: keyword
+`,
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	fields, err := LoadFieldsFromGlob(filepath.Join(dir, "[ab].yml"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"host.name", "host.ip", "message"}, fields.GetKeys())
+
+	_, err = LoadFieldsFromGlob(filepath.Join(dir, "*.yml"))
+	require.Error(t, err)
+	assert.Equal(t, filepath.Join(dir, "c.yml")+": field 'host.ip' has conflicting types: 'ip' and 'keyword' (previously defined in "+filepath.Join(dir, "b.yml")+")", err.Error())
+
+	_, err = LoadFieldsFromGlob(filepath.Join(dir, "*.json"))
+	assert.Error(t, err)
+}
+
+func TestFieldsDisabledGroup(t *testing.T) {
+	cfg, err := yaml.NewConfig([]byte(`
+- name: raw
+  type: group
+  enabled: false
+  fields:
+    - name: body
+      type: text
+    - name: size
+      type: long
+- name: message
+  type: text
+`))
+	require.NoError(t, err)
+
+	var fields Fields
+	require.NoError(t, cfg.Unpack(&fields))
+	require.NotNil(t, fields[0].Enabled)
+	assert.False(t, *fields[0].Enabled)
+
+	assert.Equal(t, []string{"raw", "message"}, fields.GetKeys())
+	assert.Equal(t, 2, fields.Count())
+	assert.True(t, fields.HasKey("raw"))
+	assert.False(t, fields.HasKey("raw.body"))
+}
+
+func TestFieldsRenameKey(t *testing.T) {
+	fields := Fields{
+		Field{Name: "process", Type: "group", Fields: Fields{
+			Field{Name: "cmdline", Type: "keyword", IgnoreAbove: 2048},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "ip", Type: "ip"},
+		}},
+	}
+
+	t.Run("leaf to new group", func(t *testing.T) {
+		renamed, err := fields.RenameKey("process.cmdline", "process.args.line")
+		require.NoError(t, err)
+		assert.Equal(t, Fields{
+			Field{Name: "host", Type: "group", Fields: Fields{
+				Field{Name: "name", Type: "keyword"},
+				Field{Name: "ip", Type: "ip"},
+			}},
+			Field{Name: "process", Type: "group", Fields: Fields{
+				Field{Name: "args", Type: "group", Fields: Fields{
+					Field{Name: "line", Type: "keyword", IgnoreAbove: 2048},
+				}},
+			}},
+		}, renamed)
+
+		// The original tree is not modified
+		assert.Equal(t, []string{"process.cmdline", "host.name", "host.ip"}, fields.GetKeys())
+	})
+
+	t.Run("group", func(t *testing.T) {
+		renamed, err := fields.RenameKey("host", "observer")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"process.cmdline", "observer.name", "observer.ip"}, renamed.GetKeys())
+	})
+
+	t.Run("with alias", func(t *testing.T) {
+		renamed, err := fields.RenameKeyWithAlias("host.name", "host.hostname")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"process.cmdline", "host.ip", "host.hostname", "host.name"}, renamed.GetKeys())
+
+		path, err := renamed.ResolveAlias("host.name")
+		require.NoError(t, err)
+		assert.Equal(t, "host.hostname", path)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := fields.RenameKey("host.os", "host.os_name")
+		assert.EqualError(t, err, "field 'host.os' not found")
+
+		_, err = fields.RenameKey("host.name", "host.ip")
+		assert.EqualError(t, err, "field 'host.ip' already exists")
+
+		_, err = fields.RenameKey("host.name", "process.cmdline.name")
+		assert.EqualError(t, err, "field 'process.cmdline.name' already exists")
+
+		_, err = fields.RenameKeyWithAlias("host", "observer")
+		assert.EqualError(t, err, "field 'host' is a group and cannot be aliased")
+	})
+}
+
+func TestFieldsApplyDefaults(t *testing.T) {
+	fields := Fields{
+		Field{Name: "untyped"},
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "raw"},
+		}},
+		Field{Name: "group", Fields: Fields{
+			Field{Name: "name"},
+			Field{Name: "count", Type: "long"},
+		}},
+		Field{Name: "object", Type: "object"},
+	}
+
+	expected := Fields{
+		Field{Name: "untyped", Type: "keyword"},
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "group", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "count", Type: "long"},
+		}},
+		Field{Name: "object", Type: "object"},
+	}
+
+	assert.Equal(t, expected, fields.ApplyDefaults())
+	assert.Equal(t, "", fields[0].Type)
+}
+
+func TestFieldsDefaultIgnoreMalformed(t *testing.T) {
+	falseVar := false
+	trueVar := true
+	fields := Fields{
+		Field{Name: "message", Type: "text"},
+		Field{Name: "group", Fields: Fields{
+			Field{Name: "count", Type: "long"},
+			Field{Name: "ip", Type: "ip", IgnoreMalformed: &falseVar},
+		}},
+		Field{Name: "timestamp", Type: "keyword", MultiFields: Fields{
+			Field{Name: "date", Type: "date"},
+		}},
+	}
+
+	expected := Fields{
+		Field{Name: "message", Type: "text"},
+		Field{Name: "group", Fields: Fields{
+			Field{Name: "count", Type: "long", IgnoreMalformed: &trueVar},
+			Field{Name: "ip", Type: "ip", IgnoreMalformed: &falseVar},
+		}},
+		Field{Name: "timestamp", Type: "keyword", MultiFields: Fields{
+			Field{Name: "date", Type: "date", IgnoreMalformed: &trueVar},
+		}},
+	}
+
+	assert.Equal(t, expected, fields.DefaultIgnoreMalformed(true))
+	assert.Nil(t, fields[1].Fields[0].IgnoreMalformed)
+}
+
+func TestFieldsValidateAliases(t *testing.T) {
+	fields := Fields{
+		Field{Name: "client", Fields: Fields{
+			Field{Name: "ip", Type: "ip"},
+			Field{Name: "address", Type: "alias", AliasPath: "client.ip"},
+		}},
+		Field{Name: "source", Fields: Fields{
+			Field{Name: "ip", Type: "alias", AliasPath: "client.address"},
+			Field{Name: "missing", Type: "alias", AliasPath: "client.port"},
+			Field{Name: "group", Type: "alias", AliasPath: "client"},
+		}},
+	}
+
+	err := fields.ValidateAliases()
+	require.Error(t, err)
+	merr, ok := err.(*multierror.MultiError)
+	require.True(t, ok)
+
+	var messages []string
+	for _, e := range merr.Errors {
+		messages = append(messages, e.Error())
+	}
+	assert.Equal(t, []string{
+		"alias 'source.missing' with target 'client.port' can not be resolved: field 'client.port' not found",
+		"alias 'source.group' with target 'client' can not be resolved: field 'client' not found",
+	}, messages)
+
+	assert.NoError(t, fields[:1].ValidateAliases())
+
+	cyclic := Fields{
+		Field{Name: "a", Type: "alias", AliasPath: "b"},
+		Field{Name: "b", Type: "alias", AliasPath: "a"},
+	}
+	err = cyclic.ValidateAliases()
+	require.Error(t, err)
+	assert.Len(t, err.(*multierror.MultiError).Errors, 2)
+}
+
+// benchmarkFields returns a tree of 2000 leaf fields in groups of 3 levels.
+func benchmarkFields() Fields {
+	var fields Fields
+	for i := 0; i < 10; i++ {
+		module := Field{Name: fmt.Sprintf("module%d", i), Type: "group"}
+		for j := 0; j < 10; j++ {
+			metricset := Field{Name: fmt.Sprintf("metricset%d", j), Type: "group"}
+			for k := 0; k < 20; k++ {
+				metricset.Fields = append(metricset.Fields, Field{Name: fmt.Sprintf("field%d", k), Type: "long"})
+			}
+			module.Fields = append(module.Fields, metricset)
+		}
+		fields = append(fields, module)
+	}
+	return fields
+}
+
+func BenchmarkFieldsGetKeys(b *testing.B) {
+	fields := benchmarkFields()
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fields.GetKeys()
+	}
+}
+
+func BenchmarkFieldsHasKey(b *testing.B) {
+	fields := benchmarkFields()
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fields.HasKey("module9.metricset9.field19")
+	}
+}
+
+func BenchmarkFieldIndexHas(b *testing.B) {
+	index := benchmarkFields().BuildIndex()
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		index.Has("module9.metricset9.field19")
+	}
+}
+
+func TestFieldsDescribe(t *testing.T) {
+	falseVar := false
+	trueVar := true
+
+	fields := Fields{
+		Field{Name: "message", Type: "text", Description: "The log message.", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Description: "Host name."},
+			Field{Name: "load", Type: "scaled_float", DocValues: &falseVar},
+		}},
+		Field{Name: "payload", Type: "binary"},
+		Field{Name: "hidden", Type: "keyword", Index: &falseVar},
+		Field{Name: "override", Type: "text", Aggregatable: &trueVar, Searchable: &falseVar},
+	}
+
+	assert.Equal(t, []FieldDescriptor{
+		{Name: "message", Type: "text", Aggregatable: false, Searchable: true, Description: "The log message."},
+		{Name: "message.raw", Type: "keyword", Aggregatable: true, Searchable: true},
+		{Name: "host.name", Type: "keyword", Aggregatable: true, Searchable: true, Description: "Host name."},
+		{Name: "host.load", Type: "scaled_float", Aggregatable: false, Searchable: true},
+		{Name: "payload", Type: "binary", Aggregatable: false, Searchable: false},
+		{Name: "hidden", Type: "keyword", Aggregatable: true, Searchable: false},
+		{Name: "override", Type: "text", Aggregatable: true, Searchable: false},
+	}, fields.Describe())
+}
+
+func TestFieldsCopyTo(t *testing.T) {
+	cfg, err := yaml.NewConfig([]byte(`
+- name: all
+  type: text
+- name: message
+  type: text
+  copy_to: all
+- name: host
+  type: group
+  fields:
+    - name: name
+      type: keyword
+      copy_to: [all, host.hostname]
+    - name: hostname
+      type: keyword
+`))
+	require.NoError(t, err)
+
+	var fields Fields
+	require.NoError(t, cfg.Unpack(&fields))
+	assert.Equal(t, []string{"all"}, fields[1].CopyTo)
+	assert.NoError(t, fields.Validate())
+
+	assert.Equal(t, map[string][]string{
+		"message":   {"all"},
+		"host.name": {"all", "host.hostname"},
+	}, fields.CopyToTargets())
+
+	invalid := Fields{
+		Field{Name: "a", Type: "keyword", CopyTo: []string{"a"}},
+		Field{Name: "b", Type: "keyword", CopyTo: []string{"missing"}},
+	}
+	err = invalid.Validate()
+	require.Error(t, err)
+
+	var messages []string
+	for _, e := range err.(*multierror.MultiError).Errors {
+		messages = append(messages, e.Error())
+	}
+	assert.Equal(t, []string{
+		"a: copy_to target is the field itself",
+		"b: copy_to target 'missing' does not exist",
+	}, messages)
+}
+
+func TestFieldsPrune(t *testing.T) {
+	fields := Fields{
+		Field{Name: "empty", Type: "group"},
+		Field{Name: "untyped"},
+		Field{Name: "a", Type: "group", Fields: Fields{
+			Field{Name: "b", Type: "group", Fields: Fields{
+				Field{Name: "c", Type: "group"},
+			}},
+			Field{Name: "d", Type: "long"},
+		}},
+		Field{Name: "e", Fields: Fields{
+			Field{Name: "f", Type: "group"},
+		}},
+		Field{Name: "object", Type: "object"},
+	}
+
+	assert.Equal(t, Fields{
+		Field{Name: "untyped"},
+		Field{Name: "a", Type: "group", Fields: Fields{
+			Field{Name: "d", Type: "long"},
+		}},
+		Field{Name: "object", Type: "object"},
+	}, fields.Prune())
+
+	assert.Len(t, fields, 5)
+	assert.Nil(t, Fields{Field{Name: "empty", Type: "group"}}.Prune())
+}
+
+func TestFieldsGeoPoint(t *testing.T) {
+	fields := Fields{
+		Field{Name: "location", Type: "geo_point", Fields: Fields{
+			Field{Name: "lat", Type: "float"},
+			Field{Name: "lon", Type: "float"},
+		}},
+		Field{Name: "city", Type: "keyword"},
+	}
+
+	assert.Equal(t, []string{"location", "city"}, fields.GetKeys())
+	assert.Equal(t, 2, fields.Count())
+	assert.Equal(t, map[string]string{"location": "geo_point", "city": "keyword"}, fields.TypeMap())
+	assert.False(t, fields.HasKey("location.lat"))
+}
+
+func TestFieldsMarshalYAML(t *testing.T) {
+	falseVar := false
+	fields := Fields{
+		Field{Name: "message", Type: "text", Description: "The message.", Norms: &falseVar, Analyzer: "simple", SearchAnalyzer: "whitespace", Similarity: "BM25", TermVector: "yes", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "labels", Type: "object", Dynamic: DynamicType{true}, ObjectTypeParams: []ObjectTypeCfg{
+			{ObjectType: "scaled_float", ObjectTypeMappingType: "float", ScalingFactor: 100},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", CopyTo: []string{"all"}},
+			Field{Name: "url", UrlTemplate: []VersionizedString{{MinVersion: "6.0.0", Value: "http://{{value}}"}}},
+		}},
+		Field{Name: "all", Type: "text", Index: &falseVar},
+		Field{Name: "count", Type: "long", Coerce: &falseVar},
+	}
+
+	expected := `- name: message
+  type: text
+  description: The message.
+  multi_fields:
+  - name: raw
+    type: keyword
+  analyzer: simple
+  search_analyzer: whitespace
+  norms: false
+  similarity: BM25
+  term_vector: "yes"
+- name: labels
+  type: object
+  dynamic: true
+  object_type_params:
+  - object_type: scaled_float
+    object_type_mapping_type: float
+    scaling_factor: 100
+- name: host
+  type: group
+  fields:
+  - name: name
+    copy_to:
+    - all
+  - name: url
+    url_template:
+    - min_version: 6.0.0
+      value: http://{{value}}
+- name: all
+  type: text
+  index: false
+- name: count
+  type: long
+  coerce: false
+`
+
+	out, err := yamlv2.Marshal(fields)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(out))
+
+	cfg, err := yaml.NewConfig(out)
+	require.NoError(t, err)
+	var unpacked Fields
+	require.NoError(t, cfg.Unpack(&unpacked))
+
+	assert.Equal(t, fields, unpacked)
+	for i := range fields {
+		assert.True(t, fields[i].Equal(unpacked[i]), fields[i].Name)
+	}
+}
+
+func TestFieldsIntersect(t *testing.T) {
+	a := Fields{
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name"},
+			Field{Name: "ip", Type: "ip"},
+			Field{Name: "id", Type: "keyword"},
+		}},
+		Field{Name: "message", Type: "text"},
+		Field{Name: "only_a", Type: "long"},
+	}
+	b := Fields{
+		Field{Name: "message", Type: "text"},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "ip", Type: "keyword"},
+		}},
+		Field{Name: "only_b", Type: "long"},
+	}
+
+	expected := Fields{
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name"},
+		}},
+		Field{Name: "message", Type: "text"},
+	}
+	assert.Equal(t, expected, a.Intersect(b))
+	assert.Equal(t, []string{"message", "host.name"}, b.Intersect(a).GetKeys())
+	assert.Nil(t, a.Intersect(Fields{}))
+}
+
+func TestFieldsNormalizeNames(t *testing.T) {
+	fields := Fields{
+		Field{Name: "Host", Type: "group", Fields: Fields{
+			Field{Name: "Name", Type: "keyword", MultiFields: Fields{
+				Field{Name: "Text", Type: "text"},
+			}},
+			Field{Name: "ip", Type: "ip"},
+		}},
+		Field{Name: "message", Type: "text"},
+		Field{Name: "message", Type: "text"},
+	}
+
+	normalized, changed, err := fields.NormalizeNames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Host", "Host.Name", "Host.Name.Text"}, changed)
+	assert.Equal(t, []string{"host.name", "host.name.text", "host.ip", "message", "message"}, normalized.GetKeys())
+	assert.Equal(t, "Host", fields[0].Name)
+
+	_, changed, err = normalized.NormalizeNames()
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	collision := Fields{
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "Name", Type: "keyword"},
+		}},
+	}
+	_, _, err = collision.NormalizeNames()
+	assert.EqualError(t, err, "fields 'host.name' and 'host.Name' collide once lowercased")
+}
+
+func TestFieldsMeta(t *testing.T) {
+	cfg, err := yaml.NewConfig([]byte(`
+- name: host
+  type: group
+  meta:
+    owner: infra
+  fields:
+    - name: name
+      type: keyword
+      meta:
+        owner: platform
+        since: 6.5.0
+    - name: ip
+      type: ip
+`))
+	require.NoError(t, err)
+
+	var fields Fields
+	require.NoError(t, cfg.Unpack(&fields))
+
+	meta, found := fields.MetaFor("host.name")
+	require.True(t, found)
+	assert.Equal(t, MapStr{"owner": "platform", "since": "6.5.0"}, meta)
+
+	meta, found = fields.MetaFor("host")
+	require.True(t, found)
+	assert.Equal(t, MapStr{"owner": "infra"}, meta)
+
+	_, found = fields.MetaFor("host.ip")
+	assert.False(t, found)
+
+	// The returned metadata is a copy
+	meta.Put("owner", "other")
+	meta, _ = fields.MetaFor("host")
+	assert.Equal(t, "infra", meta["owner"])
+
+	out, err := yamlv2.Marshal(fields)
+	require.NoError(t, err)
+	cfg, err = yaml.NewConfig(out)
+	require.NoError(t, err)
+	var unpacked Fields
+	require.NoError(t, cfg.Unpack(&unpacked))
+	assert.Equal(t, fields, unpacked)
+}
+
+func TestFieldsValidateAgainstECS(t *testing.T) {
+	ecs := Fields{
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "ip", Type: "ip"},
+			Field{Name: "os", Type: "group", Fields: Fields{
+				Field{Name: "family", Type: "keyword"},
+			}},
+		}},
+		Field{Name: "message", Type: "text"},
+	}
+
+	valid := Fields{
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name"},
+			Field{Name: "custom", Type: "long"},
+		}},
+		Field{Name: "message", Type: "text"},
+		Field{Name: "module", Type: "keyword"},
+	}
+	assert.NoError(t, valid.ValidateAgainstECS(ecs))
+
+	invalid := Fields{
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "ip", Type: "keyword"},
+			Field{Name: "os", Type: "keyword"},
+			Field{Name: "name", Type: "group", Fields: Fields{
+				Field{Name: "short", Type: "keyword"},
+			}},
+		}},
+		Field{Name: "message", Type: "keyword"},
+	}
+	err := invalid.ValidateAgainstECS(ecs)
+	require.Error(t, err)
+
+	var messages []string
+	for _, e := range err.(*multierror.MultiError).Errors {
+		messages = append(messages, e.Error())
+	}
+	assert.Equal(t, []string{
+		"field 'host.ip' of type 'keyword' conflicts with ECS field of type 'ip'",
+		"field 'host.os' of type 'keyword' conflicts with ECS object 'host.os'",
+		"field 'host.name.short' is nested under ECS field 'host.name' of type 'keyword'",
+		"field 'message' of type 'keyword' conflicts with ECS field of type 'text'",
+	}, messages)
+}
+
+func TestFieldsExportJSONSchema(t *testing.T) {
+	falseVar := false
+	fields := Fields{
+		Field{Name: "@timestamp", Type: "date", Description: "Event time.\n"},
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name"},
+			Field{Name: "cpu.pct", Type: "scaled_float"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "uptime", Type: "long"},
+		}},
+		Field{Name: "hostname", Type: "alias", AliasPath: "host.name"},
+		Field{Name: "labels", Type: "object"},
+		Field{Name: "location", Type: "geo_point"},
+		Field{Name: "raw", Enabled: &falseVar, Fields: Fields{
+			Field{Name: "body"},
+		}},
+	}
+
+	data, err := fields.ExportJSONSchema()
+	require.NoError(t, err)
+
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &schema))
+
+	assert.Equal(t, map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"@timestamp": map[string]interface{}{"type": "string", "format": "date-time", "description": "Event time."},
+			"message":    map[string]interface{}{"type": "string"},
+			"host": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{"type": "string"},
+					"cpu": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"pct": map[string]interface{}{"type": "number"},
+						},
+					},
+					"uptime": map[string]interface{}{"type": "integer"},
+				},
+			},
+			"hostname": map[string]interface{}{"type": "string"},
+			"labels":   map[string]interface{}{"type": "object"},
+			"location": map[string]interface{}{},
+			"raw":      map[string]interface{}{"type": "object"},
+		},
+	}, schema)
+}
+
+func TestFieldsAnalyzersUsed(t *testing.T) {
+	fields := Fields{
+		Field{Name: "message", Type: "text", Analyzer: "simple", MultiFields: Fields{
+			Field{Name: "english", Type: "text", Analyzer: "english", SearchAnalyzer: "simple"},
+		}},
+		Field{Name: "process", Type: "group", Fields: Fields{
+			Field{Name: "args", Type: "text", Analyzer: "whitespace"},
+			Field{Name: "name"},
+		}},
+	}
+
+	assert.Equal(t, []string{"english", "simple", "whitespace"}, fields.AnalyzersUsed())
+	assert.Empty(t, Fields{Field{Name: "name"}}.AnalyzersUsed())
+}
+
+func TestFieldsBuildIndex(t *testing.T) {
+	fields := Fields{
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Type: "keyword"},
+			Field{Name: "os.family"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", Type: "text"},
+			Field{Name: "uptime", Type: "long"},
+		}},
+		Field{Name: "hostname", Type: "alias", AliasPath: "host.name"},
+		Field{Name: "location", Type: "geo_point", Fields: Fields{
+			Field{Name: "lat"},
+		}},
+	}
+
+	index := fields.BuildIndex()
+	assert.Equal(t, 7, index.Len())
+	for _, key := range []string{"message", "message.raw", "host.name", "host.os.family", "host.uptime", "hostname", "location"} {
+		assert.True(t, index.Has(key), key)
+	}
+	for _, key := range []string{"host", "host.os", "location.lat", "missing"} {
+		assert.False(t, index.Has(key), key)
+	}
+
+	field, found := index.Get("host.name")
+	assert.True(t, found)
+	assert.Equal(t, "keyword", field.Type)
+
+	field, found = index.Get("hostname")
+	assert.True(t, found)
+	assert.Equal(t, "alias", field.Type)
+
+	_, found = index.Get("host")
+	assert.False(t, found)
+}
+
+func TestFieldsStripDescriptions(t *testing.T) {
+	fields := Fields{
+		Field{Name: "host", Type: "group", Description: "Host info.", Fields: Fields{
+			Field{Name: "name", Description: "Host name.", MultiFields: Fields{
+				Field{Name: "text", Type: "text", Description: "Analyzed name."},
+			}},
+		}},
+	}
+
+	stripped := fields.StripDescriptions()
+	assert.Equal(t, Fields{
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name", MultiFields: Fields{
+				Field{Name: "text", Type: "text"},
+			}},
+		}},
+	}, stripped)
+	assert.Equal(t, "Analyzed name.", fields[0].Fields[0].MultiFields[0].Description)
+}
+
+func TestFieldForVersion(t *testing.T) {
+	trueVar := true
+	field := Field{
+		Name:        "load",
+		Type:        "scaled_float",
+		Description: "Load.",
+		Fields:      Fields{Field{Name: "child"}},
+		Overrides: map[string]Field{
+			"v2": {Name: "ignored", Type: "float", DocValues: &trueVar, Fields: Fields{Field{Name: "ignored"}}},
+		},
+	}
+
+	assert.Equal(t, field, field.ForVersion(7))
+
+	overridden := field.ForVersion(2)
+	assert.Equal(t, "load", overridden.Name)
+	assert.Equal(t, "float", overridden.Type)
+	assert.Equal(t, "Load.", overridden.Description)
+	assert.Equal(t, &trueVar, overridden.DocValues)
+	assert.Equal(t, Fields{Field{Name: "child"}}, overridden.Fields)
+	assert.Equal(t, "scaled_float", field.Type)
+}
+
+func TestFieldsFingerprint(t *testing.T) {
+	falseVar := false
+	fields := Fields{
+		Field{Name: "message", Type: "text", Description: "The message.", MultiFields: Fields{
+			Field{Name: "raw", Type: "keyword"},
+			Field{Name: "english", Type: "text", Analyzer: "english"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name"},
+			Field{Name: "ip", Type: "ip"},
+		}},
+	}
+	reordered := Fields{
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "ip", Type: "ip"},
+		}},
+		Field{Name: "message", Type: "text", MultiFields: Fields{
+			Field{Name: "english", Type: "text", Analyzer: "english"},
+			Field{Name: "raw", Type: "keyword"},
+		}},
+		Field{Name: "host", Type: "group", Fields: Fields{
+			Field{Name: "name"},
+		}},
+	}
+
+	fingerprint := fields.Fingerprint()
+	assert.Len(t, fingerprint, 64)
+	assert.Equal(t, fingerprint, fields.Fingerprint())
+	assert.Equal(t, fingerprint, reordered.Fingerprint())
+
+	changed := fields.Clone()
+	changed[1].Fields[1].Index = &falseVar
+	assert.NotEqual(t, fingerprint, changed.Fingerprint())
+
+	changed = fields.Clone()
+	changed[1].Fields[0].Name = "hostname"
+	assert.NotEqual(t, fingerprint, changed.Fingerprint())
+
+	// Conflicting definitions of the same key don't depend on their order.
+	conflicting := Fields{
+		Field{Name: "x", Type: "keyword"},
+		Field{Name: "x", Type: "long"},
+	}
+	swapped := Fields{
+		Field{Name: "x", Type: "long"},
+		Field{Name: "x", Type: "keyword"},
+	}
+	assert.Equal(t, conflicting.Fingerprint(), swapped.Fingerprint())
+
+	assert.Equal(t, "message.raw", fields[0].Name+"."+fields[0].MultiFields[0].Name)
+}
+
+func TestFieldsValidateEvent(t *testing.T) {
+	fields := Fields{
+		Field{Name: "host", Fields: Fields{
+			Field{Name: "name"},
+			Field{Name: "ip", Type: "ip"},
+		}},
+		Field{Name: "http", Fields: Fields{
+			Field{Name: "status_code", Type: "short"},
+			Field{Name: "bytes", Type: "long"},
+			Field{Name: "duration", Type: "float"},
+		}},
+		Field{Name: "message", Type: "text"},
+		Field{Name: "@timestamp", Type: "date"},
+		Field{Name: "success", Type: "boolean"},
+		Field{Name: "size", Type: "alias", AliasPath: "http.bytes"},
+		Field{Name: "labels", Type: "object"},
+	}
+
+	valid := MapStr{
+		"host": MapStr{
+			"name": "server",
+			"ip":   []string{"10.0.0.1", "::1"},
+		},
+		"http.status_code": 200,
+		"http": MapStr{
+			"bytes":    uint64(1024),
+			"duration": 1.5,
+		},
+		"message":    "hello",
+		"@timestamp": "2019-01-02T03:04:05.678Z",
+		"success":    true,
+		"size":       2048.0,
+		"labels":     MapStr{"env": "prod"},
+		"dynamic":    MapStr{"bytes": "unknown"},
+	}
+	assert.Empty(t, fields.ValidateEvent(valid))
+
+	// Values decoded with UseNumber, numbers are accepted for strings.
+	decoded := MapStr{
+		"http": MapStr{
+			"status_code": json.Number("200"),
+			"bytes":       json.Number("1024"),
+			"duration":    json.Number("1.5"),
+		},
+		"message": 42,
+		"host":    MapStr{"name": json.Number("1")},
+		"size":    json.Number("2048.0"),
+	}
+	assert.Empty(t, fields.ValidateEvent(decoded))
+
+	invalid := MapStr{
+		"host": MapStr{
+			"name": MapStr{"first": "server"},
+			"ip":   []interface{}{"10.0.0.1", "not an ip"},
+		},
+		"http": MapStr{
+			"status_code": 100000,
+			"bytes":       "1024",
+			"duration":    nil,
+		},
+		"message":    true,
+		"@timestamp": "yesterday",
+		"success":    "true",
+		"size":       json.Number("1.5"),
+	}
+	var messages []string
+	for _, err := range fields.ValidateEvent(invalid) {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal(t, []string{
+		"field '@timestamp' has a value of type string, but is declared as date",
+		"field 'host.ip' has a value of type []interface {}, but is declared as ip",
+		"field 'host.name' has a value of type common.MapStr, but is declared as keyword",
+		"field 'http.bytes' has a value of type string, but is declared as long",
+		"field 'http.status_code' has a value of type int, but is declared as short",
+		"field 'message' has a value of type bool, but is declared as text",
+		"field 'size' has a value of type json.Number, but is declared as long",
+		"field 'success' has a value of type string, but is declared as boolean",
+	}, messages)
+}
+
+func TestFieldsClone(t *testing.T) {
+	newFields := func() Fields {
+		trueVar := true
+		precision := 2
+		return Fields{
+			Field{Name: "http", Fields: Fields{
+				Field{Name: "message", Type: "text", Norms: &trueVar, CopyTo: []string{"all"}, MultiFields: Fields{
+					Field{Name: "keyword", Type: "keyword"},
+				}},
+				Field{Name: "metrics", Type: "object", ObjectTypeParams: []ObjectTypeCfg{
+					{ObjectType: "scaled_float", ScalingFactor: 100},
+				}},
+				Field{Name: "bytes", Type: "long", Format: "bytes", OutputPrecision: &precision},
+			}},
+		}
+	}
+	fields := newFields()
+
+	clone := fields.Clone()
+	assert.Equal(t, fields, clone)
+
+	subtree := clone[0].Fields
+	subtree[0].Name = "body"
+	*subtree[0].Norms = false
+	subtree[0].CopyTo[0] = "other"
+	subtree[0].MultiFields[0].Type = "text"
+	subtree[1].ObjectTypeParams[0].ScalingFactor = 10
+	*subtree[2].OutputPrecision = 4
+	clone[0].Fields = append(subtree, Field{Name: "status", Type: "long"})
+
+	assert.Equal(t, newFields(), fields)
+	assert.Nil(t, Fields(nil).Clone())
+}
+
+func TestFieldsDimensions(t *testing.T) {
+	falseVar := false
+	trueVar := true
+	fields := Fields{
+		Field{Name: "kubernetes", Fields: Fields{
+			Field{Name: "pod", TimeSeriesDimension: &trueVar},
+			Field{Name: "node", Type: "keyword", TimeSeriesDimension: &falseVar},
+			Field{Name: "cpu", Type: "double", TimeSeriesMetric: "gauge"},
+		}},
+		Field{Name: "host", Type: "text", MultiFields: Fields{
+			Field{Name: "keyword", Type: "keyword", TimeSeriesDimension: &trueVar},
+		}},
+	}
+
+	assert.Equal(t, []string{"kubernetes.pod", "host.keyword"}, fields.Dimensions())
+	assert.Nil(t, Fields{}.Dimensions())
+}
@@ -20,7 +20,12 @@ package common
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
+	"path"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -38,8 +43,36 @@ const (
 var (
 	// ErrKeyNotFound indicates that the specified key was not found.
 	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrKeyTypeMismatch indicates that a value found while resolving a key is
+	// not of the type required, e.g. a scalar where a map is expected.
+	ErrKeyTypeMismatch = errors.New("key type mismatch")
+
+	// ErrInvalidPath indicates that a key can not be used as a path into the
+	// map, e.g. a non-numeric index into a slice.
+	ErrInvalidPath = errors.New("invalid key path")
+
+	// errSliceElement indicates that a key addresses a slice element itself,
+	// which can be read but not modified.
+	errSliceElement = newKeyError(ErrInvalidPath, "key addresses a slice element which can not be modified")
 )
 
+// keyError is an error returned by key lookups. It keeps a descriptive
+// message while allowing callers to check the kind of the error with
+// errors.Cause.
+type keyError struct {
+	cause error
+	msg   string
+}
+
+func newKeyError(cause error, format string, args ...interface{}) error {
+	return &keyError{cause: cause, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *keyError) Error() string { return e.msg }
+func (e *keyError) Cause() error  { return e.cause }
+func (e *keyError) Unwrap() error { return e.cause }
+
 // EventMetadata contains fields and tags that can be added to an event via
 // configuration.
 type EventMetadata struct {
@@ -61,41 +94,143 @@ func (m MapStr) Update(d MapStr) {
 	}
 }
 
+// ArrayMergeMode defines how DeepUpdateWith combines slice values present
+// under the same key in both maps.
+type ArrayMergeMode uint8
+
+const (
+	// ArrayReplace replaces the existing value with the new slice.
+	ArrayReplace ArrayMergeMode = iota
+
+	// ArrayAppend appends the new value to the existing one if either of them
+	// is a slice. Non-slice values are added as single elements.
+	ArrayAppend
+
+	// ArrayConcat concatenates the elements of both values if both of them are
+	// slices. Otherwise the existing value is replaced.
+	ArrayConcat
+)
+
 // DeepUpdate recursively copies the key-value pairs from d to this map.
 // If the key is present and a map as well, the sub-map will be updated recursively
 // via DeepUpdate.
 func (m MapStr) DeepUpdate(d MapStr) {
+	m.DeepUpdateWith(d, ArrayReplace)
+}
+
+// DeepUpdateWith recursively copies the key-value pairs from d to this map
+// like DeepUpdate. Slice values present in both maps are combined according to
+// arrays. For all other conflicting values the value from d is used.
+func (m MapStr) DeepUpdateWith(d MapStr, arrays ArrayMergeMode) {
 	for k, v := range d {
 		switch val := v.(type) {
 		case map[string]interface{}:
-			m[k] = deepUpdateValue(m[k], MapStr(val))
+			m[k] = deepUpdateValue(m[k], MapStr(val), arrays)
 		case MapStr:
-			m[k] = deepUpdateValue(m[k], val)
+			m[k] = deepUpdateValue(m[k], val, arrays)
 		default:
-			m[k] = v
+			if old, exists := m[k]; exists && arrays != ArrayReplace {
+				m[k] = mergeArrays(old, v, arrays)
+			} else {
+				m[k] = v
+			}
 		}
 	}
 }
 
-func deepUpdateValue(old interface{}, val MapStr) interface{} {
+func deepUpdateValue(old interface{}, val MapStr, arrays ArrayMergeMode) interface{} {
 	if old == nil {
 		return val
 	}
 
 	switch sub := old.(type) {
 	case MapStr:
-		sub.DeepUpdate(val)
+		sub.DeepUpdateWith(val, arrays)
 		return sub
 	case map[string]interface{}:
 		tmp := MapStr(sub)
-		tmp.DeepUpdate(val)
+		tmp.DeepUpdateWith(val, arrays)
 		return tmp
 	default:
 		return val
 	}
 }
 
-// Delete deletes the given key from the map.
+// mergeArrays combines the existing value dst and the new value src according
+// to mode. A new slice is always allocated, so the inputs are not modified.
+func mergeArrays(dst, src interface{}, mode ArrayMergeMode) interface{} {
+	if dst == nil || src == nil {
+		return src
+	}
+
+	dstValue, srcValue := reflect.ValueOf(dst), reflect.ValueOf(src)
+	dstIsSlice, srcIsSlice := isMergeableSlice(dstValue), isMergeableSlice(srcValue)
+
+	switch {
+	case mode == ArrayAppend && (dstIsSlice || srcIsSlice):
+	case mode == ArrayConcat && dstIsSlice && srcIsSlice:
+	default:
+		return src
+	}
+
+	if dstIsSlice && srcIsSlice && dstValue.Type() == srcValue.Type() {
+		merged := reflect.MakeSlice(dstValue.Type(), 0, dstValue.Len()+srcValue.Len())
+		merged = reflect.AppendSlice(merged, dstValue)
+		return reflect.AppendSlice(merged, srcValue).Interface()
+	}
+
+	var merged []interface{}
+	for _, v := range []reflect.Value{dstValue, srcValue} {
+		if !isMergeableSlice(v) {
+			merged = append(merged, v.Interface())
+			continue
+		}
+		for i := 0; i < v.Len(); i++ {
+			merged = append(merged, v.Index(i).Interface())
+		}
+	}
+	return merged
+}
+
+// isMergeableSlice returns true if v is a slice, except for []byte which is
+// handled as a single value.
+func isMergeableSlice(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8
+}
+
+// MergeReporting recursively copies the key-value pairs from other to this map
+// like DeepUpdate and returns the sorted dotted keys of the values that were
+// overwritten. Nested maps are merged, so only leaf values are reported. A map
+// replacing a value or being replaced by one is reported under its own key.
+// Values overwritten by an equal value are not reported.
+func (m MapStr) MergeReporting(other MapStr) []string {
+	var overwritten []string
+	m.mergeReporting("", other, &overwritten)
+	sort.Strings(overwritten)
+	return overwritten
+}
+
+func (m MapStr) mergeReporting(prefix string, other MapStr, overwritten *[]string) {
+	for k, v := range other {
+		old, exists := m[k]
+		if newMap, ok := tryToMapStr(v); ok {
+			if oldMap, ok := tryToMapStr(old); ok {
+				oldMap.mergeReporting(prefix+k+".", newMap, overwritten)
+				m[k] = oldMap
+				continue
+			}
+		}
+		if exists && !reflect.DeepEqual(old, v) {
+			*overwritten = append(*overwritten, prefix+k)
+		}
+		m[k] = v
+	}
+}
+
+// Delete deletes the given key from the map. The key can be expressed in
+// dot-notation (e.g. x.y) to delete a value from a nested map. Parent maps
+// are kept, even if they become empty. ErrKeyNotFound is returned if any
+// segment of the key is missing.
 func (m MapStr) Delete(key string) error {
 	k, d, _, found, err := mapFind(key, m, false)
 	if err != nil {
@@ -104,14 +239,67 @@ func (m MapStr) Delete(key string) error {
 	if !found {
 		return ErrKeyNotFound
 	}
+	if d == nil {
+		return errSliceElement
+	}
 
 	delete(d, k)
 	return nil
 }
 
-// CopyFieldsTo copies the field specified by key to the given map. It will
-// overwrite the key if it exists. An error is returned if the key does not
-// exist in the source map.
+// RenameKey moves the value found under oldKey to newKey. Both keys can be
+// expressed in dot-notation. Missing parents of newKey are created and maps
+// left empty by removing oldKey are removed. ErrKeyNotFound is returned if
+// oldKey does not exist. A key can not be moved into its own value. If the
+// value can not be put under newKey, it is put back under oldKey.
+func (m MapStr) RenameKey(oldKey, newKey string) error {
+	value, err := m.GetValue(oldKey)
+	if err != nil {
+		return err
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	if strings.HasPrefix(newKey, oldKey+".") {
+		return errors.Errorf("can not move key '%s' into its own value '%s'", oldKey, newKey)
+	}
+
+	if err := m.Delete(oldKey); err != nil {
+		return err
+	}
+	m.deleteEmptyParents(oldKey)
+
+	if _, err := m.Put(newKey, value); err != nil {
+		m.Put(oldKey, value)
+		return err
+	}
+	return nil
+}
+
+// deleteEmptyParents removes the parent maps of key, starting from the
+// innermost one, as long as they are empty.
+func (m MapStr) deleteEmptyParents(key string) {
+	segments := SplitKey(key)
+	for i := range segments {
+		segments[i] = EscapeKey(segments[i])
+	}
+	for i := len(segments) - 1; i > 0; i-- {
+		parentKey := strings.Join(segments[:i], ".")
+		parent, err := m.GetValue(parentKey)
+		if err != nil {
+			return
+		}
+		if sub, ok := tryToMapStr(parent); !ok || len(sub) > 0 || m.Delete(parentKey) != nil {
+			return
+		}
+	}
+}
+
+// CopyFieldsTo copies the field specified by key to the given map. The key can
+// be expressed in dot-notation (e.g. x.y), missing intermediate maps are
+// created in the destination. It will overwrite the key if it exists.
+// ErrKeyNotFound is returned and the destination is left untouched if the key
+// does not exist in the source map.
 func (m MapStr) CopyFieldsTo(to MapStr, key string) error {
 	v, err := m.GetValue(key)
 	if err != nil {
@@ -123,20 +311,67 @@ func (m MapStr) CopyFieldsTo(to MapStr, key string) error {
 }
 
 // Clone returns a copy of the MapStr. It recursively makes copies of inner
-// maps.
+// maps and slices. Other values are shared with the original map.
 func (m MapStr) Clone() MapStr {
-	result := MapStr{}
+	result := make(MapStr, len(m))
 
 	for k, v := range m {
-		if innerMap, ok := tryToMapStr(v); ok {
-			v = innerMap.Clone()
-		}
-		result[k] = v
+		result[k] = cloneValue(v)
 	}
 
 	return result
 }
 
+func cloneValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case MapStr:
+		return val.Clone()
+	case map[string]interface{}:
+		return MapStr(val).Clone()
+	case []interface{}:
+		if val == nil {
+			return val
+		}
+		c := make([]interface{}, len(val))
+		for i, elem := range val {
+			c[i] = cloneValue(elem)
+		}
+		return c
+	case []MapStr:
+		if val == nil {
+			return val
+		}
+		c := make([]MapStr, len(val))
+		for i, elem := range val {
+			c[i] = elem.Clone()
+		}
+		return c
+	case []map[string]interface{}:
+		if val == nil {
+			return val
+		}
+		c := make([]map[string]interface{}, len(val))
+		for i, elem := range val {
+			c[i] = MapStr(elem).Clone()
+		}
+		return c
+	case []string:
+		if val == nil {
+			return val
+		}
+		return append([]string(nil), val...)
+	}
+
+	// Copy other slices, the elements are shared.
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.IsNil() {
+		return v
+	}
+	c := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+	reflect.Copy(c, rv)
+	return c.Interface()
+}
+
 // HasKey returns true if the key exist. If an error occurs then false is
 // returned with a non-nil error.
 func (m MapStr) HasKey(key string) (bool, error) {
@@ -144,8 +379,22 @@ func (m MapStr) HasKey(key string) (bool, error) {
 	return hasKey, err
 }
 
+// HasKeyPath returns true if the full dotted key exists in the map. Unlike
+// HasKey it never returns an error: a key whose intermediate segment is not a
+// map (or an invalid slice index) is reported as missing.
+func (m MapStr) HasKeyPath(key string) bool {
+	hasKey, err := m.HasKey(key)
+	return err == nil && hasKey
+}
+
 // GetValue gets a value from the map. If the key does not exist then an error
-// is returned.
+// is returned. Numeric segments of the key index into slices (e.g. a.0.b).
+//
+// The cause of the returned error (see errors.Cause) is ErrKeyNotFound if the
+// key is missing, ErrKeyTypeMismatch if a segment of the key resolves to a
+// value which is not a map or slice, and ErrInvalidPath if the key can not be
+// resolved, like a non-numeric slice index. Put and Delete return the same
+// errors.
 func (m MapStr) GetValue(key string) (interface{}, error) {
 	_, _, v, found, err := mapFind(key, m, false)
 	if err != nil {
@@ -157,24 +406,259 @@ func (m MapStr) GetValue(key string) (interface{}, error) {
 	return v, nil
 }
 
+// GetString gets the string value of the given key. ErrKeyTypeMismatch is
+// the cause of the returned error if the value is not a string.
+func (m MapStr) GetString(key string) (string, error) {
+	v, err := m.GetValue(key)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", typeMismatch("string", key, v)
+	}
+	return s, nil
+}
+
+// GetInt gets the value of the given key as int. Besides integer types, it
+// accepts floats without fractional part and json.Number, as found in decoded
+// JSON. Strings are not converted. ErrKeyTypeMismatch is the cause of the
+// returned error if the value can not be converted.
+func (m MapStr) GetInt(key string) (int, error) {
+	v, err := m.GetValue(key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n := v.(type) {
+	case float64:
+		if i := int(n); float64(i) == n {
+			return i, nil
+		}
+	case float32:
+		if i := int(n); float32(i) == n {
+			return i, nil
+		}
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return int(i), nil
+		}
+	case string:
+	default:
+		if i, ok := TryToInt(v); ok {
+			return i, nil
+		}
+	}
+	return 0, typeMismatch("int", key, v)
+}
+
+// GetFloat gets the value of the given key as float64. Besides float types,
+// it accepts integer types and json.Number. Strings are not converted.
+// ErrKeyTypeMismatch is the cause of the returned error if the value can not
+// be converted.
+func (m MapStr) GetFloat(key string) (float64, error) {
+	v, err := m.GetValue(key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case json.Number:
+		if f, err := n.Float64(); err == nil {
+			return f, nil
+		}
+	case string:
+	default:
+		if i, ok := TryToInt(v); ok {
+			return float64(i), nil
+		}
+	}
+	return 0, typeMismatch("float", key, v)
+}
+
+// GetBool gets the bool value of the given key. ErrKeyTypeMismatch is the
+// cause of the returned error if the value is not a bool.
+func (m MapStr) GetBool(key string) (bool, error) {
+	v, err := m.GetValue(key)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, typeMismatch("bool", key, v)
+	}
+	return b, nil
+}
+
+// GetSlice gets the []interface{} value of the given key, as found in decoded
+// JSON. The slice is returned without copying, so it shares its elements with
+// the map and must not be kept after the map has been released, e.g. after an
+// event has been published. ErrKeyTypeMismatch is the cause of the returned
+// error if the value is not a []interface{}. Slices of other types, like
+// []MapStr, can not be converted without copying and are reported as a
+// mismatch too, use GetValue for them.
+func (m MapStr) GetSlice(key string) ([]interface{}, error) {
+	v, err := m.GetValue(key)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := v.([]interface{})
+	if !ok {
+		return nil, typeMismatch("slice", key, v)
+	}
+	return s, nil
+}
+
+func typeMismatch(expected, key string, v interface{}) error {
+	return newKeyError(ErrKeyTypeMismatch, "expected %s at '%s' but type is %T", expected, key, v)
+}
+
 // Put associates the specified value with the specified key. If the map
 // previously contained a mapping for the key, the old value is replaced and
-// returned. The key can be expressed in dot-notation (e.g. x.y) to put a value
-// into a nested map.
+// returned, otherwise nil is returned. The key can be expressed in
+// dot-notation (e.g. x.y) to put a value into a nested map. The key is only
+// resolved once, so there is no need to call GetValue first to get the value
+// being replaced.
+//
+// Dots that are part of a key must be escaped with a backslash (e.g.
+// labels.app\.kubernetes\.io/name), see EscapeKey.
 //
-// If you need insert keys containing dots then you must use bracket notation
-// to insert values (e.g. m[key] = value).
+// Numeric segments of the key index into existing slices (e.g. items.2.name),
+// but the slice elements themselves can not be replaced.
 func (m MapStr) Put(key string, value interface{}) (interface{}, error) {
 	// XXX `safemapstr.Put` mimics this implementation, both should be updated to have similar behavior
 	k, d, old, _, err := mapFind(key, m, true)
 	if err != nil {
 		return nil, err
 	}
+	if d == nil {
+		return nil, errSliceElement
+	}
 
 	d[k] = value
 	return old, nil
 }
 
+// Append appends the values to the slice found under the given key,
+// creating the slice and missing parent maps like Put if the key does not
+// exist. Slices of a concrete element type, like []string, keep their type
+// if all values are of that type and are converted to []interface{}
+// otherwise. ErrKeyTypeMismatch is the cause of the returned error if the
+// existing value is not a slice.
+func (m MapStr) Append(key string, values ...interface{}) error {
+	k, d, old, present, err := mapFind(key, m, true)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return errSliceElement
+	}
+
+	if !present || old == nil {
+		d[k] = append([]interface{}(nil), values...)
+		return nil
+	}
+
+	existing := reflect.ValueOf(old)
+	if !isMergeableSlice(existing) {
+		return typeMismatch("slice", key, old)
+	}
+	if arr, ok := old.([]interface{}); ok {
+		d[k] = append(arr, values...)
+		return nil
+	}
+
+	elemType := existing.Type().Elem()
+	typed := true
+	for _, v := range values {
+		if v == nil || !reflect.TypeOf(v).AssignableTo(elemType) {
+			typed = false
+			break
+		}
+	}
+	if typed {
+		for _, v := range values {
+			existing = reflect.Append(existing, reflect.ValueOf(v))
+		}
+		d[k] = existing.Interface()
+		return nil
+	}
+
+	arr := make([]interface{}, 0, existing.Len()+len(values))
+	for i := 0; i < existing.Len(); i++ {
+		arr = append(arr, existing.Index(i).Interface())
+	}
+	d[k] = append(arr, values...)
+	return nil
+}
+
+// PutStrict is an alias of Put for callers only interested in the error. Put
+// never replaces an intermediate value that is not a map, it returns an error
+// naming the conflicting part of the key instead.
+func (m MapStr) PutStrict(key string, value interface{}) error {
+	_, err := m.Put(key, value)
+	return err
+}
+
+// PutPrefixed associates the value with the key under the given prefix, like
+// Put with `prefix + "." + key`. The key is used as is if the prefix is empty.
+func (m MapStr) PutPrefixed(prefix, key string, value interface{}) error {
+	if prefix != "" {
+		key = prefix + "." + key
+	}
+	_, err := m.Put(key, value)
+	return err
+}
+
+// PutUnderNonConflicting merges the top-level keys of data into m. Keys not
+// present in m are added as is, keys already present in m, and the prefix
+// itself, are put under the prefix instead, so existing values are never
+// replaced. The prefix is created if it does not exist. Keys that also exist
+// under the prefix, or all conflicting keys if the prefix holds a value that is
+// not a map, can not be merged and are returned, nil is returned if all keys
+// were merged.
+func (m MapStr) PutUnderNonConflicting(prefix string, data MapStr) MapStr {
+	var conflicts MapStr
+	for k, v := range data {
+		if _, exists := m[k]; !exists && k != prefix {
+			m[k] = v
+			continue
+		}
+		if conflicts == nil {
+			conflicts = MapStr{}
+		}
+		conflicts[k] = v
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	namespace := MapStr{}
+	if existing, exists := m[prefix]; exists {
+		var ok bool
+		if namespace, ok = tryToMapStr(existing); !ok {
+			return conflicts
+		}
+	}
+	var unmerged MapStr
+	for k, v := range conflicts {
+		if _, exists := namespace[k]; exists {
+			if unmerged == nil {
+				unmerged = MapStr{}
+			}
+			unmerged[k] = v
+			continue
+		}
+		namespace[k] = v
+	}
+	m[prefix] = namespace
+	return unmerged
+}
+
 // StringToPrint returns the MapStr as pretty JSON.
 func (m MapStr) StringToPrint() string {
 	json, err := json.MarshalIndent(m, "", "  ")
@@ -184,6 +668,166 @@ func (m MapStr) StringToPrint() string {
 	return string(json)
 }
 
+// MaskedString returns the MapStr as pretty JSON like StringToPrint, with the
+// values of the sensitive keys replaced by "***". Sensitive keys are given in
+// dot-notation and can contain glob patterns (e.g. *.password), as supported
+// by path.Match. Elements of slices are addressed by their index. The original
+// map is not modified.
+func (m MapStr) MaskedString(sensitive []string) string {
+	masked := m.Clone()
+	for k, v := range masked {
+		masked[k] = maskValue(k, v, sensitive)
+	}
+	return masked.StringToPrint()
+}
+
+// maskValue replaces in place the values of v matching a sensitive key.
+func maskValue(key string, v interface{}, sensitive []string) interface{} {
+	if matchesAnyKey(key, sensitive) {
+		return "***"
+	}
+
+	subKey := func(k string) string {
+		return key + "." + k
+	}
+
+	switch val := v.(type) {
+	case MapStr:
+		for k, elem := range val {
+			val[k] = maskValue(subKey(k), elem, sensitive)
+		}
+	case []interface{}:
+		for i, elem := range val {
+			val[i] = maskValue(subKey(strconv.Itoa(i)), elem, sensitive)
+		}
+	case []MapStr:
+		for i, elem := range val {
+			maskValue(subKey(strconv.Itoa(i)), elem, sensitive)
+		}
+	case []map[string]interface{}:
+		for i, elem := range val {
+			maskValue(subKey(strconv.Itoa(i)), MapStr(elem), sensitive)
+		}
+	}
+	return v
+}
+
+// Select returns a new map only containing the given keys, keeping their
+// nested structure. Keys are given in dot-notation and can contain glob
+// patterns (e.g. host.*), as supported by path.Match, where * also matches
+// dots. Selecting a key holding a map selects all of its content, keys not
+// found are ignored. Values are copied, the original map is not modified.
+func (m MapStr) Select(keys []string) MapStr {
+	return selectKeys("", m, keys)
+}
+
+func selectKeys(prefix string, m MapStr, keys []string) MapStr {
+	selected := MapStr{}
+	for k, v := range m {
+		fullKey := k
+		if prefix != "" {
+			fullKey = prefix + "." + k
+		}
+
+		if matchesAnyKey(fullKey, keys) {
+			selected[k] = cloneValue(v)
+		} else if inner, ok := tryToMapStr(v); ok {
+			if sub := selectKeys(fullKey, inner, keys); len(sub) > 0 {
+				selected[k] = sub
+			}
+		}
+	}
+	return selected
+}
+
+// matchesAnyKey returns true if key matches one of the patterns.
+func matchesAnyKey(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, key); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterValues returns a new map only containing the leaf values for which
+// keep returns true. Like Walk, keep is called with the dotted key of every
+// leaf and slices are handled as a whole. Maps left without any values are
+// removed. Values are copied, the original map is not modified.
+func (m MapStr) FilterValues(keep func(key string, value interface{}) bool) MapStr {
+	return filterValues("", m, keep)
+}
+
+func filterValues(prefix string, m MapStr, keep func(key string, value interface{}) bool) MapStr {
+	filtered := MapStr{}
+	for k, v := range m {
+		fullKey := k
+		if prefix != "" {
+			fullKey = prefix + "." + k
+		}
+
+		if inner, ok := tryToMapStr(v); ok {
+			if sub := filterValues(fullKey, inner, keep); len(sub) > 0 {
+				filtered[k] = sub
+			}
+		} else if keep(fullKey, v) {
+			filtered[k] = cloneValue(v)
+		}
+	}
+	return filtered
+}
+
+// Walk calls fn for every leaf value of the map with its dotted key,
+// recursing into nested maps. Slices are passed to fn as a whole. The order
+// in which the values are visited is unspecified.
+func (m MapStr) Walk(fn func(key string, value interface{})) {
+	walkValue("", m, false, fn)
+}
+
+// WalkSlices is like Walk, but also recurses into slices, addressing their
+// elements by index (e.g. items.0.name).
+func (m MapStr) WalkSlices(fn func(key string, value interface{})) {
+	walkValue("", m, true, fn)
+}
+
+func walkValue(key string, v interface{}, slices bool, fn func(key string, value interface{})) {
+	subKey := func(k string) string {
+		if key == "" {
+			return k
+		}
+		return key + "." + k
+	}
+
+	if m, ok := tryToMapStr(v); ok {
+		for k, elem := range m {
+			walkValue(subKey(k), elem, slices, fn)
+		}
+		return
+	}
+
+	if slices {
+		switch val := v.(type) {
+		case []interface{}:
+			for i, elem := range val {
+				walkValue(subKey(strconv.Itoa(i)), elem, slices, fn)
+			}
+			return
+		case []MapStr:
+			for i, elem := range val {
+				walkValue(subKey(strconv.Itoa(i)), elem, slices, fn)
+			}
+			return
+		case []map[string]interface{}:
+			for i, elem := range val {
+				walkValue(subKey(strconv.Itoa(i)), MapStr(elem), slices, fn)
+			}
+			return
+		}
+	}
+
+	fn(key, v)
+}
+
 // String returns the MapStr as JSON.
 func (m MapStr) String() string {
 	bytes, err := json.Marshal(m)
@@ -224,7 +868,8 @@ func (m MapStr) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 // This is converted to:
 //   "hello.world": "test"
 //
-// This can be useful for testing or logging.
+// Nested MapStr and map[string]interface{} values are flattened, slices are
+// kept as is. This can be useful for testing or logging.
 func (m MapStr) Flatten() MapStr {
 	return flatten("", m, MapStr{})
 }
@@ -249,6 +894,309 @@ func flatten(prefix string, in, out MapStr) MapStr {
 	return out
 }
 
+// FromFlattened is the inverse of Flatten, it expands the dotted keys of flat
+// into nested maps, so `a.b.c` is put under the key c of the map b of the map
+// a. Dots can be escaped to keep them in a key, see SplitKey. Map values are
+// copied, other keys can be expanded into them. ErrKeyTypeMismatch is the
+// cause of the returned error if the keys are inconsistent, like `a` with a
+// scalar value and `a.b`, or if a key is defined twice.
+//
+// Expanding the result of Flatten returns the original structure, except for
+// empty maps, which are dropped by Flatten.
+func FromFlattened(flat MapStr) (MapStr, error) {
+	out := MapStr{}
+	for _, key := range sortedKeys(flat) {
+		value := flat[key]
+		if m, ok := tryToMapStr(value); ok {
+			value = m.Clone()
+		}
+
+		segments := SplitKey(key)
+		d := out
+		for i, segment := range segments[:len(segments)-1] {
+			next, exists := d[segment]
+			if !exists {
+				next = MapStr{}
+				d[segment] = next
+			}
+			m, ok := next.(MapStr)
+			if !ok {
+				return nil, newKeyError(ErrKeyTypeMismatch, "key '%s' conflicts with the value of '%s'", key, strings.Join(segments[:i+1], "."))
+			}
+			d = m
+		}
+
+		last := segments[len(segments)-1]
+		if _, exists := d[last]; exists {
+			return nil, newKeyError(ErrKeyTypeMismatch, "key '%s' is defined more than once", key)
+		}
+		d[last] = value
+	}
+	return out, nil
+}
+
+// CountLeaves returns the number of scalar values in the map, including the
+// ones of nested maps. The elements of slices are counted one by one, maps in
+// slices are counted recursively. Empty maps and slices have no leaves.
+func (m MapStr) CountLeaves() int {
+	count := 0
+	for _, v := range m {
+		count += countLeaves(v)
+	}
+	return count
+}
+
+func countLeaves(v interface{}) int {
+	if m, ok := tryToMapStr(v); ok {
+		return m.CountLeaves()
+	}
+	if rv := reflect.ValueOf(v); isCollection(rv) {
+		count := 0
+		for i := 0; i < rv.Len(); i++ {
+			count += countLeaves(rv.Index(i).Interface())
+		}
+		return count
+	}
+	return 1
+}
+
+// Depth returns the maximum nesting depth of the map, that is 0 for an empty
+// map, 1 for a map only containing scalar values and one more for each level
+// of nested maps. Slices don't add a level, but maps in slices do.
+func (m MapStr) Depth() int {
+	if len(m) == 0 {
+		return 0
+	}
+	max := 0
+	for _, v := range m {
+		if d := depth(v); d > max {
+			max = d
+		}
+	}
+	return max + 1
+}
+
+func depth(v interface{}) int {
+	if m, ok := tryToMapStr(v); ok {
+		return m.Depth()
+	}
+	max := 0
+	if rv := reflect.ValueOf(v); isCollection(rv) {
+		for i := 0; i < rv.Len(); i++ {
+			if d := depth(rv.Index(i).Interface()); d > max {
+				max = d
+			}
+		}
+	}
+	return max
+}
+
+// isCollection returns true if the value is a slice or an array, other than a
+// byte slice, which is a single binary value.
+func isCollection(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice:
+		return rv.Type().Elem().Kind() != reflect.Uint8
+	case reflect.Array:
+		return true
+	}
+	return false
+}
+
+// MapStrDiff holds the differences between two MapStr, keyed by the dotted
+// keys as returned by Flatten.
+type MapStrDiff struct {
+	// Removed contains the keys only present in the receiver.
+	Removed []string
+	// Added contains the keys only present in the other MapStr.
+	Added []string
+	// Changed contains the keys present in both MapStr with different values.
+	Changed []MapStrChange
+	// Values contains the values of the removed and added keys.
+	Values map[string]interface{}
+}
+
+// String renders the differences one key per line, sorted by key, in a
+// unified diff like format: `+ key: value` for added keys, `- key: value` for
+// removed keys and `~ key: old -> new` for changed keys. Maps and slices are
+// shown as JSON.
+func (d MapStrDiff) String() string {
+	type line struct {
+		key, text string
+	}
+	lines := make([]line, 0, len(d.Removed)+len(d.Added)+len(d.Changed))
+	for _, key := range d.Removed {
+		lines = append(lines, line{key, fmt.Sprintf("- %s: %s", key, formatDiffValue(d.Values[key]))})
+	}
+	for _, key := range d.Added {
+		lines = append(lines, line{key, fmt.Sprintf("+ %s: %s", key, formatDiffValue(d.Values[key]))})
+	}
+	for _, change := range d.Changed {
+		lines = append(lines, line{change.Key, fmt.Sprintf("~ %s: %s -> %s", change.Key, formatDiffValue(change.Old), formatDiffValue(change.New))})
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].key < lines[j].key })
+
+	texts := make([]string, len(lines))
+	for i, l := range lines {
+		texts[i] = l.text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// formatDiffValue formats a value for MapStrDiff.String. Strings are shown as
+// is, maps and slices as JSON.
+func formatDiffValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return "null"
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		if b, err := json.Marshal(value); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// MapStrChange describes the value of a key that differs between two MapStr.
+type MapStrChange struct {
+	Key string
+	Old interface{}
+	New interface{}
+}
+
+// Diff compares m with other, recursing into nested maps. Any other values,
+// including slices, are compared as a whole using reflect.DeepEqual. All keys
+// are reported sorted. A key changing from a map to another value is
+// reported as the keys of the map being removed and the key being added.
+func (m MapStr) Diff(other MapStr) MapStrDiff {
+	var diff MapStrDiff
+
+	oldValues := m.Flatten()
+	newValues := other.Flatten()
+
+	for _, key := range sortedKeys(oldValues) {
+		newValue, found := newValues[key]
+		if !found {
+			diff.Removed = append(diff.Removed, key)
+			diff.addValue(key, oldValues[key])
+			continue
+		}
+		if oldValue := oldValues[key]; !reflect.DeepEqual(oldValue, newValue) {
+			diff.Changed = append(diff.Changed, MapStrChange{Key: key, Old: oldValue, New: newValue})
+		}
+	}
+
+	for _, key := range sortedKeys(newValues) {
+		if _, found := oldValues[key]; !found {
+			diff.Added = append(diff.Added, key)
+			diff.addValue(key, newValues[key])
+		}
+	}
+
+	return diff
+}
+
+func (d *MapStrDiff) addValue(key string, value interface{}) {
+	if d.Values == nil {
+		d.Values = map[string]interface{}{}
+	}
+	d.Values[key] = value
+}
+
+// EqualsIgnoringNumericType compares m with other, recursing into nested
+// maps and slices. Numbers are compared by value, so values of different
+// numeric types, like an int and the float64 or json.Number it is decoded to
+// from JSON, are equal. MapStr and map[string]interface{} are compared by their
+// content, as are slices of different element types. Other values are
+// compared using reflect.DeepEqual.
+func (m MapStr) EqualsIgnoringNumericType(other MapStr) bool {
+	return valuesEqual(m, other)
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if ma, ok := tryToMapStr(a); ok {
+		mb, ok := tryToMapStr(b)
+		if !ok || len(ma) != len(mb) {
+			return false
+		}
+		for k, va := range ma {
+			vb, found := mb[k]
+			if !found || !valuesEqual(va, vb) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if na, ok := toNumber(a); ok {
+		nb, ok := toNumber(b)
+		return ok && na.Cmp(nb) == 0
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if isMergeableSlice(va) && isMergeableSlice(vb) {
+		if va.Len() != vb.Len() {
+			return false
+		}
+		for i := 0; i < va.Len(); i++ {
+			if !valuesEqual(va.Index(i).Interface(), vb.Index(i).Interface()) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// toNumber returns the exact value of v if it is a number or a json.Number.
+// Integer json.Numbers are kept exact, others are converted to float64 like
+// json.Unmarshal does, so they compare equal to the decoded float64 values.
+// NaN is not considered a number, as it is not equal to itself.
+func toNumber(v interface{}) (*big.Float, bool) {
+	if n, ok := v.(json.Number); ok {
+		if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			return new(big.Float).SetInt64(i), true
+		}
+		if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			return new(big.Float).SetUint64(u), true
+		}
+		f, err := strconv.ParseFloat(string(n), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		return new(big.Float).SetFloat64(f), true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Float).SetInt64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Float).SetUint64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		if math.IsNaN(rv.Float()) {
+			return nil, false
+		}
+		return new(big.Float).SetFloat64(rv.Float()), true
+	default:
+		return nil, false
+	}
+}
+
+func sortedKeys(m MapStr) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // MapStrUnion creates a new MapStr containing the union of the
 // key-value pairs of the two maps. If the same key is present in
 // both, the key-value pairs from dict2 overwrite the ones from dict1.
@@ -321,6 +1269,10 @@ func AddTagsWithKey(ms MapStr, key string, tags []string) error {
 		return err
 	}
 
+	if subMap == nil {
+		return errSliceElement
+	}
+
 	if !present {
 		subMap[k] = tags
 		return nil
@@ -335,7 +1287,7 @@ func AddTagsWithKey(ms MapStr, key string, tags []string) error {
 		}
 		subMap[k] = arr
 	default:
-		return errors.Errorf("expected string array by type is %T", oldTags)
+		return newKeyError(ErrKeyTypeMismatch, "expected string array by type is %T", oldTags)
 
 	}
 	return nil
@@ -347,7 +1299,7 @@ func AddTagsWithKey(ms MapStr, key string, tags []string) error {
 func toMapStr(v interface{}) (MapStr, error) {
 	m, ok := tryToMapStr(v)
 	if !ok {
-		return nil, errors.Errorf("expected map but type is %T", v)
+		return nil, newKeyError(ErrKeyTypeMismatch, "expected map but type is %T", v)
 	}
 	return m, nil
 }
@@ -363,6 +1315,55 @@ func tryToMapStr(v interface{}) (MapStr, bool) {
 	}
 }
 
+// SplitKey splits a dotted key into its segments. Dots escaped with a
+// backslash (e.g. `labels.app\.kubernetes\.io/name`) don't separate segments
+// and are unescaped, so keys containing dots can be addressed by GetValue,
+// Put and the other dotted key methods. Use EscapeKey to build such keys.
+func SplitKey(key string) []string {
+	var segments []string
+	for {
+		idx := keySeparator(key)
+		if idx < 0 {
+			return append(segments, unescapeKey(key))
+		}
+		segments = append(segments, unescapeKey(key[:idx]))
+		key = key[idx+1:]
+	}
+}
+
+// EscapeKey escapes the dots of a single key segment, so it is not split when
+// used as part of a dotted key.
+func EscapeKey(segment string) string {
+	return strings.Replace(segment, ".", `\.`, -1)
+}
+
+// keySeparator returns the index of the first dot in key not escaped with a
+// backslash, or -1 if there is none.
+func keySeparator(key string) int {
+	if strings.IndexByte(key, '\\') < 0 {
+		return strings.IndexByte(key, '.')
+	}
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '\\':
+			if i+1 < len(key) && key[i+1] == '.' {
+				i++
+			}
+		case '.':
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeKey removes the backslashes escaping dots in a key segment.
+func unescapeKey(segment string) string {
+	if strings.IndexByte(segment, '\\') < 0 {
+		return segment
+	}
+	return strings.Replace(segment, `\.`, ".", -1)
+}
+
 // mapFind iterates a MapStr based on a the given dotted key, finding the final
 // subMap and subKey to operate on.
 // An error is returned if some intermediate is no map or the key doesn't exist.
@@ -371,25 +1372,34 @@ func tryToMapStr(v interface{}) (MapStr, bool) {
 // subKey and subMap. The subMap already contains a value for subKey, the
 // present flag is set to true and the oldValue return will hold
 // the original value.
+// Numeric segments following a slice value index into the slice. Indices out
+// of range are reported as not present, like a missing key, or return
+// ErrKeyNotFound if createMissing is set. If the key ends with an index, the
+// element is returned as oldValue with a nil subMap.
+// Dots escaped with a backslash are part of the segment, see SplitKey.
 func mapFind(
 	key string,
 	data MapStr,
 	createMissing bool,
 ) (subKey string, subMap MapStr, oldValue interface{}, present bool, err error) {
-	// XXX `safemapstr.mapFind` mimics this implementation, both should be updated to have similar behavior
+	// XXX `safemapstr.mapFind` mimics this implementation, both should be updated to have similar behavior.
+	// Slice indices are not supported by `safemapstr.mapFind`.
 
+	fullKey := key
 	for {
 		// Fast path, key is present as is.
 		if v, exists := data[key]; exists {
 			return key, data, v, true, nil
 		}
 
-		idx := strings.IndexRune(key, '.')
+		idx := keySeparator(key)
 		if idx < 0 {
-			return key, data, nil, false, nil
+			key = unescapeKey(key)
+			v, exists := data[key]
+			return key, data, v, exists, nil
 		}
 
-		k := key[:idx]
+		k := unescapeKey(key[:idx])
 		d, exists := data[k]
 		if !exists {
 			if createMissing {
@@ -400,13 +1410,85 @@ func mapFind(
 			}
 		}
 
-		v, err := toMapStr(d)
-		if err != nil {
-			return "", nil, nil, false, err
+		// advance to sub-key
+		key = key[idx+1:]
+
+		// resolve (possibly nested) slice indices
+		for isSlice(d) {
+			seg, rest := key, ""
+			last := true
+			if i := keySeparator(key); i >= 0 {
+				seg, rest, last = key[:i], key[i+1:], false
+			}
+
+			elem, err := sliceElem(d, seg, createMissing && !last)
+			if err == ErrKeyNotFound && !createMissing {
+				return "", nil, nil, false, nil
+			}
+			if err != nil {
+				return "", nil, nil, false, err
+			}
+			if last {
+				return "", nil, elem, true, nil
+			}
+			d, key = elem, rest
+		}
+
+		v, ok := tryToMapStr(d)
+		if !ok {
+			// report the part of the key already resolved
+			path := fullKey[:len(fullKey)-len(key)-1]
+			return "", nil, nil, false, newKeyError(ErrKeyTypeMismatch, "expected map at '%s' but type is %T", path, d)
 		}
 
 		// advance to sub-map
-		key = key[idx+1:]
 		data = v
 	}
 }
+
+func isSlice(v interface{}) bool {
+	switch v.(type) {
+	case []interface{}, []MapStr, []map[string]interface{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// sliceElem returns the element of the slice v at the index given by seg.
+// If createMissing is set, a nil element is replaced by an empty map.
+func sliceElem(v interface{}, seg string, createMissing bool) (interface{}, error) {
+	i, err := strconv.Atoi(seg)
+	if err != nil {
+		return nil, newKeyError(ErrInvalidPath, "expected numeric slice index but got '%s'", seg)
+	}
+
+	switch s := v.(type) {
+	case []interface{}:
+		if i < 0 || i >= len(s) {
+			return nil, ErrKeyNotFound
+		}
+		if s[i] == nil && createMissing {
+			s[i] = MapStr{}
+		}
+		return s[i], nil
+	case []MapStr:
+		if i < 0 || i >= len(s) {
+			return nil, ErrKeyNotFound
+		}
+		if s[i] == nil && createMissing {
+			s[i] = MapStr{}
+		}
+		return s[i], nil
+	case []map[string]interface{}:
+		if i < 0 || i >= len(s) {
+			return nil, ErrKeyNotFound
+		}
+		if s[i] == nil && createMissing {
+			s[i] = map[string]interface{}{}
+		}
+		return s[i], nil
+	default:
+		return nil, newKeyError(ErrKeyTypeMismatch, "expected slice but type is %T", v)
+	}
+}
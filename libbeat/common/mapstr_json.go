@@ -0,0 +1,274 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// jsonFlushSize is the size of the encoding buffer after which it is written
+// to the underlying writer.
+const jsonFlushSize = 4096
+
+const hexDigits = "0123456789abcdef"
+
+// EncodeJSON writes the MapStr as JSON to w, without building the complete
+// document in memory first. The output matches json.Marshal, except for
+// time.Time values which are encoded in the TsLayout format like Time.
+func (m MapStr) EncodeJSON(w io.Writer) error {
+	enc := &jsonEncoder{w: w}
+	if err := enc.encodeMap(m); err != nil {
+		return err
+	}
+	return enc.flush()
+}
+
+type jsonEncoder struct {
+	w    io.Writer
+	buf  []byte
+	keys []string
+}
+
+func (e *jsonEncoder) flush() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+	_, err := e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
+
+func (e *jsonEncoder) maybeFlush() error {
+	if len(e.buf) < jsonFlushSize {
+		return nil
+	}
+	return e.flush()
+}
+
+func (e *jsonEncoder) encodeMap(m map[string]interface{}) error {
+	if m == nil {
+		e.buf = append(e.buf, "null"...)
+		return nil
+	}
+
+	// Keys are sorted like encoding/json does. The keys buffer is shared
+	// between nesting levels, every level uses the section after its parent.
+	offset := len(e.keys)
+	for k := range m {
+		e.keys = append(e.keys, k)
+	}
+	keys := e.keys[offset:]
+	sort.Strings(keys)
+
+	e.buf = append(e.buf, '{')
+	for i := offset; i < offset+len(keys); i++ {
+		if i > offset {
+			e.buf = append(e.buf, ',')
+		}
+		k := e.keys[i]
+		e.encodeString(k)
+		e.buf = append(e.buf, ':')
+		if err := e.encodeValue(m[k]); err != nil {
+			return err
+		}
+	}
+	e.buf = append(e.buf, '}')
+
+	e.keys = e.keys[:offset]
+	return e.maybeFlush()
+}
+
+func (e *jsonEncoder) encodeValue(v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		e.buf = append(e.buf, "null"...)
+	case MapStr:
+		return e.encodeMap(val)
+	case map[string]interface{}:
+		return e.encodeMap(val)
+	case string:
+		e.encodeString(val)
+	case bool:
+		e.buf = strconv.AppendBool(e.buf, val)
+	case int:
+		e.buf = strconv.AppendInt(e.buf, int64(val), 10)
+	case int8:
+		e.buf = strconv.AppendInt(e.buf, int64(val), 10)
+	case int16:
+		e.buf = strconv.AppendInt(e.buf, int64(val), 10)
+	case int32:
+		e.buf = strconv.AppendInt(e.buf, int64(val), 10)
+	case int64:
+		e.buf = strconv.AppendInt(e.buf, val, 10)
+	case uint:
+		e.buf = strconv.AppendUint(e.buf, uint64(val), 10)
+	case uint8:
+		e.buf = strconv.AppendUint(e.buf, uint64(val), 10)
+	case uint16:
+		e.buf = strconv.AppendUint(e.buf, uint64(val), 10)
+	case uint32:
+		e.buf = strconv.AppendUint(e.buf, uint64(val), 10)
+	case uint64:
+		e.buf = strconv.AppendUint(e.buf, val, 10)
+	case float32:
+		return e.encodeFloat(float64(val), 32)
+	case float64:
+		return e.encodeFloat(val, 64)
+	case time.Time:
+		e.encodeTime(val)
+	case Time:
+		e.encodeTime(time.Time(val))
+	case []interface{}:
+		if val == nil {
+			e.buf = append(e.buf, "null"...)
+			return nil
+		}
+		e.buf = append(e.buf, '[')
+		for i, elem := range val {
+			if i > 0 {
+				e.buf = append(e.buf, ',')
+			}
+			if err := e.encodeValue(elem); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, ']')
+	case []MapStr:
+		if val == nil {
+			e.buf = append(e.buf, "null"...)
+			return nil
+		}
+		e.buf = append(e.buf, '[')
+		for i, elem := range val {
+			if i > 0 {
+				e.buf = append(e.buf, ',')
+			}
+			if err := e.encodeMap(elem); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, ']')
+	case []string:
+		if val == nil {
+			e.buf = append(e.buf, "null"...)
+			return nil
+		}
+		e.buf = append(e.buf, '[')
+		for i, elem := range val {
+			if i > 0 {
+				e.buf = append(e.buf, ',')
+			}
+			e.encodeString(elem)
+		}
+		e.buf = append(e.buf, ']')
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		e.buf = append(e.buf, b...)
+	}
+	return e.maybeFlush()
+}
+
+func (e *jsonEncoder) encodeTime(t time.Time) {
+	e.buf = append(e.buf, '"')
+	e.buf = t.UTC().AppendFormat(e.buf, TsLayout)
+	e.buf = append(e.buf, '"')
+}
+
+// encodeFloat formats floats the same way encoding/json does.
+func (e *jsonEncoder) encodeFloat(f float64, bits int) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return &json.UnsupportedValueError{Str: strconv.FormatFloat(f, 'g', -1, bits)}
+	}
+
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	e.buf = strconv.AppendFloat(e.buf, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(e.buf)
+		if n >= 4 && e.buf[n-4] == 'e' && e.buf[n-3] == '-' && e.buf[n-2] == '0' {
+			e.buf[n-2] = e.buf[n-1]
+			e.buf = e.buf[:n-1]
+		}
+	}
+	return nil
+}
+
+// encodeString quotes and escapes s the same way encoding/json does,
+// including the escaping of HTML characters.
+func (e *jsonEncoder) encodeString(s string) {
+	e.buf = append(e.buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			e.buf = append(e.buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				e.buf = append(e.buf, '\\', b)
+			case '\n':
+				e.buf = append(e.buf, '\\', 'n')
+			case '\r':
+				e.buf = append(e.buf, '\\', 'r')
+			case '\t':
+				e.buf = append(e.buf, '\\', 't')
+			default:
+				e.buf = append(e.buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			e.buf = append(e.buf, s[start:i]...)
+			e.buf = append(e.buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			e.buf = append(e.buf, s[start:i]...)
+			e.buf = append(e.buf, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	e.buf = append(e.buf, s[start:]...)
+	e.buf = append(e.buf, '"')
+}
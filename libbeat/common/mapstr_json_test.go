@@ -0,0 +1,136 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapStrEncodeJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input MapStr
+	}{
+		{"nil", nil},
+		{"empty", MapStr{}},
+		{"flat", MapStr{"b": 1, "a": "x", "c": true, "d": nil}},
+		{"numbers", MapStr{
+			"int": -1, "int8": int8(-8), "int16": int16(16), "int32": int32(32), "int64": int64(math.MaxInt64),
+			"uint": uint(1), "uint8": uint8(8), "uint16": uint16(16), "uint32": uint32(32), "uint64": uint64(math.MaxUint64),
+			"float32": float32(1.5), "float64": 3.1415, "small": 1e-7, "large": 1e21, "zero": 0.0,
+		}},
+		{"strings", MapStr{
+			"escape": "quote \" backslash \\ newline \n tab \t control \x01",
+			"html":   "<a href=\"x\">&</a>",
+			"utf8":   "Grüße \u2028 \u2029 \xff",
+			"<key>":  "value",
+		}},
+		{"nested", MapStr{
+			"a": MapStr{"c": 1, "b": map[string]interface{}{"z": "y", "x": MapStr{}}},
+			"d": MapStr(nil),
+		}},
+		{"slices", MapStr{
+			"interfaces": []interface{}{1, "a", MapStr{"b": 2}, nil},
+			"maps":       []MapStr{{"a": 1}, {"b": 2}},
+			"strings":    []string{"a", "b"},
+			"nilStrings": []string(nil),
+			"ints":       []int{1, 2},
+		}},
+		{"nil interfaces", MapStr{"a": []interface{}(nil)}},
+		{"nil maps", MapStr{"a": []MapStr(nil), "b": []MapStr{nil}}},
+		{"nil strings", MapStr{"a": []string(nil)}},
+		{"other", MapStr{"struct": struct{ A int }{1}, "ptr": &struct{ B string }{"b"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected, err := json.Marshal(test.input)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, test.input.EncodeJSON(&buf))
+			assert.Equal(t, string(expected), buf.String())
+		})
+	}
+}
+
+func TestMapStrEncodeJSONTime(t *testing.T) {
+	ts := time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.FixedZone("test", 3600))
+	m := MapStr{"time": ts, "common": Time(ts)}
+
+	var buf bytes.Buffer
+	require.NoError(t, m.EncodeJSON(&buf))
+	assert.Equal(t, `{"common":"2019-01-02T02:04:05.678Z","time":"2019-01-02T02:04:05.678Z"}`, buf.String())
+}
+
+func TestMapStrEncodeJSONUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Error(t, MapStr{"nan": math.NaN()}.EncodeJSON(&buf))
+	assert.Error(t, MapStr{"chan": make(chan int)}.EncodeJSON(&buf))
+}
+
+func TestMapStrEncodeJSONLarge(t *testing.T) {
+	m := MapStr{}
+	for i := 0; i < 1000; i++ {
+		m.Put(string(rune('a'+i%26))+string(rune('a'+i/26))+".value", i)
+	}
+
+	expected, err := json.Marshal(m)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, m.EncodeJSON(&buf))
+	assert.Equal(t, string(expected), buf.String())
+}
+
+var benchmarkJSONEvent = MapStr{
+	"@timestamp": "2019-01-02T03:04:05.678Z",
+	"message":    "GET /index.html HTTP/1.1 200 1234",
+	"host":       MapStr{"name": "localhost", "ip": []string{"127.0.0.1", "::1"}},
+	"source":     MapStr{"ip": "10.0.0.1", "port": 52345, "geo": MapStr{"lat": 52.52, "lon": 13.405}},
+	"http":       MapStr{"response": MapStr{"status_code": 200, "body": MapStr{"bytes": int64(1234)}}},
+	"tags":       []interface{}{"web", "production"},
+}
+
+func BenchmarkMapStrEncodeJSON(b *testing.B) {
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		benchmarkJSONEvent.EncodeJSON(&buf)
+	}
+}
+
+func BenchmarkMapStrJSONMarshal(b *testing.B) {
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		data, _ := json.Marshal(benchmarkJSONEvent)
+		buf.Write(data)
+	}
+}
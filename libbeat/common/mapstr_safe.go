@@ -0,0 +1,78 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import "sync"
+
+// SafeMapStr wraps a MapStr to be safely shared between goroutines, for
+// example as registry of runtime metadata. All accesses take a lock, so it is
+// not meant to replace MapStr for event data. The zero value is an empty map
+// ready to use.
+type SafeMapStr struct {
+	mu sync.RWMutex
+	m  MapStr
+}
+
+// NewSafeMapStr returns a SafeMapStr holding a copy of the given MapStr.
+func NewSafeMapStr(m MapStr) *SafeMapStr {
+	return &SafeMapStr{m: m.Clone()}
+}
+
+// Get returns a copy of the value stored under the given key, see
+// MapStr.GetValue.
+func (s *SafeMapStr) Get(key string) (interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, err := s.m.GetValue(key)
+	if err != nil {
+		return nil, err
+	}
+	return cloneValue(v), nil
+}
+
+// Put stores a copy of the value under the given key and returns the previous
+// value, see MapStr.Put.
+func (s *SafeMapStr) Put(key string, value interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.m == nil {
+		s.m = MapStr{}
+	}
+	return s.m.Put(key, cloneValue(value))
+}
+
+// Delete deletes the given key, see MapStr.Delete.
+func (s *SafeMapStr) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.m.Delete(key)
+}
+
+// Snapshot returns a deep copy of the map, which can be used without locking.
+func (s *SafeMapStr) Snapshot() MapStr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.m == nil {
+		return MapStr{}
+	}
+	return s.m.Clone()
+}
@@ -0,0 +1,98 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package common
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafeMapStr(t *testing.T) {
+	data := MapStr{"host": MapStr{"name": "a"}}
+	s := NewSafeMapStr(data)
+
+	// The initial map is copied
+	data.Put("host.name", "b")
+	v, err := s.Get("host.name")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", v)
+
+	old, err := s.Put("host.name", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", old)
+
+	// Returned values are copies
+	host, err := s.Get("host")
+	assert.NoError(t, err)
+	host.(MapStr)["name"] = "d"
+
+	snapshot := s.Snapshot()
+	assert.Equal(t, MapStr{"host": MapStr{"name": "c"}}, snapshot)
+	snapshot.Put("host.name", "e")
+
+	assert.NoError(t, s.Delete("host.name"))
+	assert.Equal(t, MapStr{"host": MapStr{}}, s.Snapshot())
+
+	_, err = s.Get("host.name")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	var zero SafeMapStr
+	assert.Equal(t, MapStr{}, zero.Snapshot())
+	_, err = zero.Put("a.b", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, MapStr{"a": MapStr{"b": 1}}, zero.Snapshot())
+}
+
+// TestSafeMapStrConcurrent is meant to be run with -race.
+func TestSafeMapStrConcurrent(t *testing.T) {
+	s := NewSafeMapStr(MapStr{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("writer%d.key%d", i, j%10)
+				s.Put(key, MapStr{"value": j})
+				if j%3 == 0 {
+					s.Delete(key)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.Get("writer0.key0")
+				s.Snapshot().Flatten()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 4; i++ {
+		v, err := s.Get(fmt.Sprintf("writer%d.key8", i))
+		assert.NoError(t, err)
+		assert.Equal(t, MapStr{"value": 98}, v)
+	}
+}
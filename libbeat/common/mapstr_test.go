@@ -15,6 +15,7 @@
 // specific language governing permissions and limitations
 // under the License.
 
+//go:build !integration
 // +build !integration
 
 package common
@@ -22,9 +23,12 @@ package common
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
 	"strings"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
 
@@ -100,6 +104,104 @@ func TestMapStrDeepUpdate(t *testing.T) {
 	}
 }
 
+func TestMapStrDeepUpdateWith(t *testing.T) {
+	tests := []struct {
+		name     string
+		arrays   ArrayMergeMode
+		a, b     MapStr
+		expected MapStr
+	}{
+		{
+			"replace",
+			ArrayReplace,
+			MapStr{"a": MapStr{"b": []string{"x"}, "c": 1}},
+			MapStr{"a": MapStr{"b": []string{"y"}}},
+			MapStr{"a": MapStr{"b": []string{"y"}, "c": 1}},
+		},
+		{
+			"append slices",
+			ArrayAppend,
+			MapStr{"a": MapStr{"b": []string{"x"}}, "c": []interface{}{1}},
+			MapStr{"a": MapStr{"b": []string{"y", "z"}}, "c": []int{2}},
+			MapStr{"a": MapStr{"b": []string{"x", "y", "z"}}, "c": []interface{}{1, 2}},
+		},
+		{
+			"append scalars",
+			ArrayAppend,
+			MapStr{"a": []string{"x"}, "b": "x", "c": "x"},
+			MapStr{"a": "y", "b": []string{"y"}, "c": "y"},
+			MapStr{"a": []interface{}{"x", "y"}, "b": []interface{}{"x", "y"}, "c": "y"},
+		},
+		{
+			"concat",
+			ArrayConcat,
+			MapStr{"a": MapStr{"b": []string{"x"}}, "c": []string{"x"}, "d": "x"},
+			MapStr{"a": MapStr{"b": []string{"y"}}, "c": "y", "d": []string{"y"}},
+			MapStr{"a": MapStr{"b": []string{"x", "y"}}, "c": "y", "d": []string{"y"}},
+		},
+		{
+			"nil values",
+			ArrayAppend,
+			MapStr{"a": nil, "b": []string{"x"}},
+			MapStr{"a": []string{"y"}, "b": nil},
+			MapStr{"a": []string{"y"}, "b": nil},
+		},
+		{
+			"bytes are not merged",
+			ArrayConcat,
+			MapStr{"a": []byte("x")},
+			MapStr{"a": []byte("y")},
+			MapStr{"a": []byte("y")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.a.DeepUpdateWith(test.b, test.arrays)
+			assert.Equal(t, test.expected, test.a)
+		})
+	}
+}
+
+func TestMapStrMergeReporting(t *testing.T) {
+	m := MapStr{
+		"message": "hello",
+		"host": MapStr{
+			"name": "a",
+			"os":   map[string]interface{}{"family": "linux"},
+		},
+		"status": MapStr{"code": 200},
+		"tags":   []string{"x"},
+	}
+	other := MapStr{
+		"message": "hello",
+		"host": MapStr{
+			"name": "b",
+			"os":   MapStr{"version": "18.04", "family": "debian"},
+			"ip":   "127.0.0.1",
+		},
+		"status": 200,
+		"tags":   []string{"y"},
+		"agent":  MapStr{"name": "beat"},
+	}
+
+	overwritten := m.MergeReporting(other)
+	assert.Equal(t, []string{"host.name", "host.os.family", "status", "tags"}, overwritten)
+	assert.Equal(t, MapStr{
+		"message": "hello",
+		"host": MapStr{
+			"name": "b",
+			"os":   MapStr{"family": "debian", "version": "18.04"},
+			"ip":   "127.0.0.1",
+		},
+		"status": 200,
+		"tags":   []string{"y"},
+		"agent":  MapStr{"name": "beat"},
+	}, m)
+
+	assert.Empty(t, m.MergeReporting(m.Clone()))
+}
+
 func TestMapStrUnion(t *testing.T) {
 	assert := assert.New(t)
 
@@ -138,7 +240,15 @@ func TestMapStrCopyFieldsTo(t *testing.T) {
 	c := MapStr{}
 
 	err := m.CopyFieldsTo(c, "dd")
-	assert.Error(err)
+	assert.Equal(ErrKeyNotFound, err)
+	assert.Equal(MapStr{}, c)
+
+	err = m.CopyFieldsTo(c, "dd.c1")
+	assert.Equal(ErrKeyNotFound, err)
+	assert.Equal(MapStr{}, c)
+
+	err = m.CopyFieldsTo(c, "c.dd")
+	assert.Equal(ErrKeyNotFound, err)
 	assert.Equal(MapStr{}, c)
 
 	err = m.CopyFieldsTo(c, "a")
@@ -158,6 +268,124 @@ func TestMapStrCopyFieldsTo(t *testing.T) {
 	assert.Equal(MapStr{"a": MapStr{"a1": 2, "a2": 3}, "c": MapStr{"c1": 1, "c3": MapStr{"c32": 2}}, "b": 2}, c)
 }
 
+func TestMapStrRenameKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    MapStr
+		oldKey   string
+		newKey   string
+		expected MapStr
+		err      error
+	}{
+		{
+			name:     "top level",
+			input:    MapStr{"a": 1, "b": 2},
+			oldKey:   "a",
+			newKey:   "c",
+			expected: MapStr{"b": 2, "c": 1},
+		},
+		{
+			name:     "nested, removing empty source branch",
+			input:    MapStr{"source": MapStr{"ip": MapStr{"v4": "10.0.0.1"}}, "other": 1},
+			oldKey:   "source.ip.v4",
+			newKey:   "client.ip",
+			expected: MapStr{"client": MapStr{"ip": "10.0.0.1"}, "other": 1},
+		},
+		{
+			name:     "keep non-empty parents",
+			input:    MapStr{"host": MapStr{"hostname": "a", "id": 1}},
+			oldKey:   "host.hostname",
+			newKey:   "host.name",
+			expected: MapStr{"host": MapStr{"name": "a", "id": 1}},
+		},
+		{
+			name:     "move map to its parent",
+			input:    MapStr{"a": MapStr{"b": MapStr{"c": 1}}},
+			oldKey:   "a.b",
+			newKey:   "a",
+			expected: MapStr{"a": MapStr{"c": 1}},
+		},
+		{
+			name:     "escaped dots",
+			input:    MapStr{"labels": MapStr{"app.kubernetes.io/name": "beat"}},
+			oldKey:   `labels.app\.kubernetes\.io/name`,
+			newKey:   "app.name",
+			expected: MapStr{"app": MapStr{"name": "beat"}},
+		},
+		{
+			name:     "same key",
+			input:    MapStr{"a": 1},
+			oldKey:   "a",
+			newKey:   "a",
+			expected: MapStr{"a": 1},
+		},
+		{
+			name:     "missing key",
+			input:    MapStr{"a": 1},
+			oldKey:   "b",
+			newKey:   "c",
+			expected: MapStr{"a": 1},
+			err:      ErrKeyNotFound,
+		},
+		{
+			name:     "destination under scalar",
+			input:    MapStr{"a": 1, "b": MapStr{"c": 2}},
+			oldKey:   "b.c",
+			newKey:   "a.c",
+			expected: MapStr{"a": 1, "b": MapStr{"c": 2}},
+			err:      ErrKeyTypeMismatch,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.input.RenameKey(test.oldKey, test.newKey)
+			assert.Equal(t, test.err, errors.Cause(err))
+			assert.Equal(t, test.expected, test.input)
+		})
+	}
+
+	m := MapStr{"a": MapStr{"b": 1}}
+	assert.Error(t, m.RenameKey("a", "a.c"))
+	assert.Equal(t, MapStr{"a": MapStr{"b": 1}}, m)
+}
+
+func TestMapStrAppend(t *testing.T) {
+	m := MapStr{
+		"tags":   []string{"a"},
+		"list":   []interface{}{1},
+		"labels": MapStr{"env": "prod"},
+		"nested": map[string]interface{}{"items": []int{1}},
+		"nil":    nil,
+	}
+
+	assert.NoError(t, m.Append("tags", "b", "c"))
+	assert.Equal(t, []string{"a", "b", "c"}, m["tags"])
+
+	assert.NoError(t, m.Append("tags", 1))
+	assert.Equal(t, []interface{}{"a", "b", "c", 1}, m["tags"])
+
+	assert.NoError(t, m.Append("list", "x"))
+	assert.Equal(t, []interface{}{1, "x"}, m["list"])
+
+	assert.NoError(t, m.Append("nested.items", 2))
+	assert.Equal(t, []int{1, 2}, m["nested"].(map[string]interface{})["items"])
+
+	assert.NoError(t, m.Append("new.path.values", "a"))
+	assert.Equal(t, MapStr{"values": []interface{}{"a"}}, m["new"].(MapStr)["path"])
+
+	assert.NoError(t, m.Append("nil", "a"))
+	assert.Equal(t, []interface{}{"a"}, m["nil"])
+
+	err := m.Append("labels.env", "dev")
+	assert.Equal(t, ErrKeyTypeMismatch, errors.Cause(err))
+	assert.EqualError(t, err, "expected slice at 'labels.env' but type is string")
+	assert.Equal(t, "prod", m["labels"].(MapStr)["env"])
+
+	err = m.Append("labels.env.sub", "dev")
+	assert.Equal(t, ErrKeyTypeMismatch, errors.Cause(err))
+}
+
 func TestMapStrDelete(t *testing.T) {
 	assert := assert.New(t)
 
@@ -184,6 +412,16 @@ func TestMapStrDelete(t *testing.T) {
 	assert.Equal(nil, err)
 	assert.Equal(MapStr{"c": MapStr{"c1": 1, "c3": MapStr{"c32": 2}}}, m)
 
+	err = m.Delete("c.c3.c33")
+	assert.Equal(ErrKeyNotFound, err)
+
+	err = m.Delete("d.c3.c32")
+	assert.Equal(ErrKeyNotFound, err)
+
+	err = m.Delete("c.c3.c32")
+	assert.Equal(nil, err)
+	assert.Equal(MapStr{"c": MapStr{"c1": 1, "c3": MapStr{}}}, m)
+
 	err = m.Delete("c")
 	assert.Equal(nil, err)
 	assert.Equal(MapStr{}, m)
@@ -228,6 +466,60 @@ func TestHasKey(t *testing.T) {
 	hasKey, err = m.HasKey("c.c4.f")
 	assert.Equal(nil, err)
 	assert.Equal(true, hasKey)
+
+	hasKey, err = m.HasKey("c.missing")
+	assert.Equal(nil, err)
+	assert.Equal(false, hasKey)
+
+	m["a"] = []interface{}{MapStr{"b": 1}}
+
+	hasKey, err = m.HasKey("a.0")
+	assert.Equal(nil, err)
+	assert.Equal(true, hasKey)
+
+	hasKey, err = m.HasKey("a.0.b")
+	assert.Equal(nil, err)
+	assert.Equal(true, hasKey)
+
+	hasKey, err = m.HasKey("a.5")
+	assert.Equal(nil, err)
+	assert.Equal(false, hasKey)
+
+	hasKey, err = m.HasKey("a.5.b")
+	assert.Equal(nil, err)
+	assert.Equal(false, hasKey)
+
+	_, err = m.GetValue("a.5")
+	assert.Equal(ErrKeyNotFound, errors.Cause(err))
+}
+
+func TestHasKeyPath(t *testing.T) {
+	m := MapStr{
+		"host": MapStr{
+			"os": MapStr{
+				"name": "linux",
+			},
+			"ip": []interface{}{"127.0.0.1"},
+		},
+		"agent.name": "beat",
+	}
+
+	tests := map[string]bool{
+		"host":           true,
+		"host.os":        true,
+		"host.os.name":   true,
+		"host.os.family": false,
+		"host.os.name.x": false,
+		"host.ip.0":      true,
+		"host.ip.1":      false,
+		"host.ip.x":      false,
+		"agent.name":     true,
+		"missing.key":    false,
+	}
+
+	for key, expected := range tests {
+		assert.Equal(t, expected, m.HasKeyPath(key), key)
+	}
 }
 
 func TestMapStrPut(t *testing.T) {
@@ -255,6 +547,18 @@ func TestMapStrPut(t *testing.T) {
 	assert.Equal(t, 1, v)
 	assert.Equal(t, MapStr{"a": "ok", "subMap": MapStr{"a": 2, "b": 2}}, m)
 
+	// Replace a whole subMap.
+	v, err = m.Put("subMap", "flat")
+	assert.NoError(t, err)
+	assert.Equal(t, MapStr{"a": 2, "b": 2}, v)
+	assert.Equal(t, MapStr{"a": "ok", "subMap": "flat"}, m)
+
+	// Put below a value which is not a map.
+	v, err = m.Put("subMap.c", 3)
+	assert.Error(t, err)
+	assert.Nil(t, v)
+	assert.Equal(t, MapStr{"a": "ok", "subMap": "flat"}, m)
+
 	// Add value to map that does not exist.
 	m = MapStr{}
 	v, err = m.Put("subMap.newMap.a", 1)
@@ -263,6 +567,97 @@ func TestMapStrPut(t *testing.T) {
 	assert.Equal(t, MapStr{"subMap": MapStr{"newMap": MapStr{"a": 1}}}, m)
 }
 
+func TestMapStrPutStrict(t *testing.T) {
+	m := MapStr{
+		"a": 1,
+		"b": MapStr{"c": "x"},
+		"d": []interface{}{"y"},
+	}
+
+	assert.NoError(t, m.PutStrict("b.e", 2))
+	assert.NoError(t, m.PutStrict("f.g", 3))
+
+	assert.EqualError(t, m.PutStrict("a.b", 4), "expected map at 'a' but type is int")
+	assert.EqualError(t, m.PutStrict("b.c.d", 5), "expected map at 'b.c' but type is string")
+	assert.EqualError(t, m.PutStrict("d.0.e", 6), "expected map at 'd.0' but type is string")
+
+	assert.Equal(t, MapStr{
+		"a": 1,
+		"b": MapStr{"c": "x", "e": 2},
+		"d": []interface{}{"y"},
+		"f": MapStr{"g": 3},
+	}, m)
+}
+
+func TestMapStrPutPrefixed(t *testing.T) {
+	m := MapStr{"a": 1}
+
+	assert.NoError(t, m.PutPrefixed("vendor", "b.c", 2))
+	assert.NoError(t, m.PutPrefixed("", "d", 3))
+	assert.Error(t, m.PutPrefixed("a", "b", 4))
+
+	assert.Equal(t, MapStr{
+		"a":      1,
+		"vendor": MapStr{"b": MapStr{"c": 2}},
+		"d":      3,
+	}, m)
+}
+
+func TestMapStrPutUnderNonConflicting(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        MapStr
+		data     MapStr
+		expected MapStr
+		rejected MapStr
+	}{
+		{
+			name:     "no conflicts",
+			m:        MapStr{"a": 1},
+			data:     MapStr{"b": 2},
+			expected: MapStr{"a": 1, "b": 2},
+		},
+		{
+			name:     "conflicting keys are namespaced",
+			m:        MapStr{"host": MapStr{"name": "x"}, "message": "m"},
+			data:     MapStr{"host": "y", "message": "n", "port": 80},
+			expected: MapStr{"host": MapStr{"name": "x"}, "message": "m", "port": 80, "vendor": MapStr{"host": "y", "message": "n"}},
+		},
+		{
+			name:     "existing namespace",
+			m:        MapStr{"a": 1, "vendor": map[string]interface{}{"b": 2}},
+			data:     MapStr{"a": 3},
+			expected: MapStr{"a": 1, "vendor": MapStr{"a": 3, "b": 2}},
+		},
+		{
+			name:     "key exists in namespace",
+			m:        MapStr{"a": 1, "vendor": MapStr{"a": 2}},
+			data:     MapStr{"a": 3, "b": 4},
+			expected: MapStr{"a": 1, "b": 4, "vendor": MapStr{"a": 2}},
+			rejected: MapStr{"a": 3},
+		},
+		{
+			name:     "prefix in data",
+			m:        MapStr{},
+			data:     MapStr{"vendor": 1},
+			expected: MapStr{"vendor": MapStr{"vendor": 1}},
+		},
+		{
+			name:     "namespace is not a map",
+			m:        MapStr{"a": 1, "vendor": "x"},
+			data:     MapStr{"a": 2, "b": 3},
+			expected: MapStr{"a": 1, "b": 3, "vendor": "x"},
+			rejected: MapStr{"a": 2},
+		},
+	}
+
+	for _, test := range tests {
+		rejected := test.m.PutUnderNonConflicting("vendor", test.data)
+		assert.Equal(t, test.rejected, rejected, test.name)
+		assert.Equal(t, test.expected, test.m, test.name)
+	}
+}
+
 func TestMapStrGetValue(t *testing.T) {
 
 	tests := []struct {
@@ -333,6 +728,93 @@ func TestMapStrGetValue(t *testing.T) {
 	}
 }
 
+func TestMapStrGetValueSliceIndex(t *testing.T) {
+	tests := []struct {
+		input  MapStr
+		key    string
+		output interface{}
+		err    error
+	}{
+		{
+			MapStr{"a": []interface{}{MapStr{"b": 1}, MapStr{"b": 2}}},
+			"a.1.b",
+			2,
+			nil,
+		},
+		{
+			MapStr{"a": []MapStr{{"b": 1}}},
+			"a.0.b",
+			1,
+			nil,
+		},
+		{
+			MapStr{"a": []map[string]interface{}{{"b": MapStr{"c": 3}}}},
+			"a.0.b.c",
+			3,
+			nil,
+		},
+		{
+			MapStr{"a": []interface{}{[]interface{}{"x", "y"}}},
+			"a.0.1",
+			"y",
+			nil,
+		},
+		{
+			MapStr{"a": []interface{}{MapStr{"b": 1}}},
+			"a.0",
+			MapStr{"b": 1},
+			nil,
+		},
+		{
+			MapStr{"a": []interface{}{MapStr{"b": 1}}},
+			"a.1.b",
+			nil,
+			ErrKeyNotFound,
+		},
+		{
+			MapStr{"a": []interface{}{MapStr{"b": 1}}},
+			"a.-1.b",
+			nil,
+			ErrKeyNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		v, err := test.input.GetValue(test.key)
+		assert.Equal(t, test.err, err, test.key)
+		assert.Equal(t, test.output, v, test.key)
+	}
+
+	_, err := MapStr{"a": []interface{}{MapStr{"b": 1}}}.GetValue("a.b")
+	assert.EqualError(t, err, "expected numeric slice index but got 'b'")
+}
+
+func TestMapStrPutSliceIndex(t *testing.T) {
+	m := MapStr{
+		"items": []interface{}{MapStr{"name": "a"}, nil, MapStr{"name": "c"}},
+	}
+
+	old, err := m.Put("items.2.name", "z")
+	assert.NoError(t, err)
+	assert.Equal(t, "c", old)
+
+	_, err = m.Put("items.1.name", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, MapStr{
+		"items": []interface{}{MapStr{"name": "a"}, MapStr{"name": "b"}, MapStr{"name": "z"}},
+	}, m)
+
+	_, err = m.Put("items.3.name", "d")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	_, err = m.Put("items.0", "a")
+	assert.Equal(t, errSliceElement, err)
+
+	assert.Equal(t, errSliceElement, m.Delete("items.0"))
+	assert.NoError(t, m.Delete("items.0.name"))
+	assert.Equal(t, MapStr{}, m["items"].([]interface{})[0])
+}
+
 func TestClone(t *testing.T) {
 	assert := assert.New(t)
 
@@ -349,6 +831,44 @@ func TestClone(t *testing.T) {
 	assert.Equal(MapStr{"c31": 1, "c32": 2}, c["c3"])
 }
 
+func TestCloneDeep(t *testing.T) {
+	m := MapStr{
+		"a": MapStr{"b": MapStr{"c": 1}},
+		"d": map[string]interface{}{"e": 2},
+		"f": []interface{}{MapStr{"g": 3}, "h"},
+		"i": []MapStr{{"j": 4}},
+		"k": []string{"l"},
+		"m": []int{5},
+		"n": "o",
+		"p": []map[string]interface{}{{"q": 6}},
+	}
+	original := MapStr{
+		"a": MapStr{"b": MapStr{"c": 1}},
+		"d": map[string]interface{}{"e": 2},
+		"f": []interface{}{MapStr{"g": 3}, "h"},
+		"i": []MapStr{{"j": 4}},
+		"k": []string{"l"},
+		"m": []int{5},
+		"n": "o",
+		"p": []map[string]interface{}{{"q": 6}},
+	}
+
+	c := m.Clone()
+	assert.Equal(t, MapStr{"e": 2}, c["d"])
+
+	c.Put("a.b.c", 10)
+	c.Put("d.e", 20)
+	c.Put("f.0.g", 30)
+	c["f"].([]interface{})[1] = "x"
+	c.Put("i.0.j", 40)
+	c["k"].([]string)[0] = "x"
+	c["m"].([]int)[0] = 50
+	c["n"] = "x"
+	c.Put("p.0.q", 60)
+
+	assert.Equal(t, original, m)
+}
+
 func TestString(t *testing.T) {
 	type io struct {
 		Input  MapStr
@@ -382,6 +902,46 @@ func TestStringToPrint(t *testing.T) {
 	assert.Equal(t, true, len(m.StringToPrint()) > 0)
 }
 
+func TestMaskedString(t *testing.T) {
+	m := MapStr{
+		"user": MapStr{"name": "elastic", "password": "changeme"},
+		"output": MapStr{
+			"elasticsearch": MapStr{"password": "secret", "hosts": []string{"localhost"}},
+		},
+		"token": MapStr{"id": 1, "value": "abc"},
+		"hosts": []interface{}{
+			MapStr{"name": "a", "password": "pw1"},
+			"b",
+		},
+		"groups": []MapStr{{"password": "pw2"}},
+		"users":  []map[string]interface{}{{"password": "pw3"}},
+	}
+	original := m.Clone()
+
+	masked := m.MaskedString([]string{"*.password", "token"})
+	assert.Equal(t, MapStr{
+		"user": MapStr{"name": "elastic", "password": "***"},
+		"output": MapStr{
+			"elasticsearch": MapStr{"password": "***", "hosts": []string{"localhost"}},
+		},
+		"token": "***",
+		"hosts": []interface{}{
+			MapStr{"name": "a", "password": "***"},
+			"b",
+		},
+		"groups": []MapStr{{"password": "***"}},
+		"users":  []map[string]interface{}{{"password": "***"}},
+	}.StringToPrint(), masked)
+	assert.Equal(t, original, m)
+
+	assert.Equal(t, m.StringToPrint(), m.MaskedString(nil))
+	assert.Equal(t, m.StringToPrint(), m.MaskedString([]string{""}))
+
+	// The root is not a key, patterns matching everything mask the top-level values.
+	assert.Equal(t, MapStr{"a": "***", "b": "***"}.StringToPrint(),
+		MapStr{"a": 1, "b": MapStr{"c": 2}}.MaskedString([]string{"*"}))
+}
+
 func TestMergeFields(t *testing.T) {
 	type io struct {
 		UnderRoot bool
@@ -661,10 +1221,42 @@ func TestFlatten(t *testing.T) {
 				"elastic.for":    "search",
 			},
 		},
+		{
+			Event: MapStr{
+				"hello": map[string]interface{}{
+					"world": []interface{}{MapStr{"ok": "test"}},
+				},
+			},
+			Expected: MapStr{
+				"hello.world": []interface{}{MapStr{"ok": "test"}},
+			},
+		},
 	}
 
 	for _, test := range tests {
-		assert.Equal(t, test.Expected, test.Event.Flatten())
+		flat := test.Event.Flatten()
+		assert.Equal(t, test.Expected, flat)
+
+		// Putting the flattened keys rebuilds the original structure.
+		rebuilt := MapStr{}
+		for k, v := range flat {
+			rebuilt.Put(k, v)
+		}
+		assert.Equal(t, test.Event.Flatten(), rebuilt.Flatten())
+	}
+}
+
+func BenchmarkMapStrClone(b *testing.B) {
+	m := MapStr{
+		"@timestamp": "2019-01-02T03:04:05.678Z",
+		"host":       MapStr{"name": "localhost", "ip": []string{"127.0.0.1", "::1"}},
+		"source":     MapStr{"ip": "10.0.0.1", "geo": MapStr{"lat": 52.52, "lon": 13.405}},
+		"tags":       []interface{}{"web", MapStr{"env": "production"}},
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.Clone()
 	}
 }
 
@@ -824,3 +1416,560 @@ func BenchmarkWalkMap(b *testing.B) {
 		}
 	})
 }
+
+func TestMapStrCountLeavesAndDepth(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  MapStr
+		leaves int
+		depth  int
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:   "flat",
+			input:  MapStr{"a": 1, "b": "x", "c": nil},
+			leaves: 3,
+			depth:  1,
+		},
+		{
+			name: "nested",
+			input: MapStr{
+				"a": 1,
+				"b": MapStr{"c": map[string]interface{}{"d": true}},
+				"e": MapStr{},
+			},
+			leaves: 2,
+			depth:  3,
+		},
+		{
+			name: "slices",
+			input: MapStr{
+				"tags":  []string{"a", "b"},
+				"empty": []interface{}{},
+				"ips":   []interface{}{"::1", []interface{}{"127.0.0.1"}},
+				"items": []MapStr{{"name": "x", "size": 1}, {"meta": MapStr{"id": 2}}},
+				"raw":   []byte("binary"),
+			},
+			leaves: 8,
+			depth:  3,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.leaves, test.input.CountLeaves(), test.name)
+		assert.Equal(t, test.depth, test.input.Depth(), test.name)
+	}
+}
+
+func TestMapStrDiff(t *testing.T) {
+	before := MapStr{
+		"message": "hello",
+		"host": MapStr{
+			"name": "a",
+			"ip":   []string{"127.0.0.1"},
+		},
+		"tags":   []interface{}{"x"},
+		"status": MapStr{"code": 200},
+	}
+	after := MapStr{
+		"message": "hello",
+		"host": MapStr{
+			"name": "b",
+			"ip":   []string{"127.0.0.1"},
+			"os":   MapStr{"name": "linux"},
+		},
+		"tags":   []interface{}{"x", "y"},
+		"status": 200,
+	}
+
+	diff := before.Diff(after)
+	assert.Equal(t, []string{"status.code"}, diff.Removed)
+	assert.Equal(t, []string{"host.os.name", "status"}, diff.Added)
+	assert.Equal(t, []MapStrChange{
+		{Key: "host.name", Old: "a", New: "b"},
+		{Key: "tags", Old: []interface{}{"x"}, New: []interface{}{"x", "y"}},
+	}, diff.Changed)
+
+	assert.Equal(t, map[string]interface{}{
+		"status.code":  200,
+		"host.os.name": "linux",
+		"status":       200,
+	}, diff.Values)
+
+	assert.Equal(t, MapStrDiff{}, before.Diff(before.Clone()))
+}
+
+func TestMapStrDiffString(t *testing.T) {
+	before := MapStr{
+		"host":   MapStr{"name": "foo"},
+		"status": 200,
+		"bytes":  10,
+		"tags":   []string{"a"},
+	}
+	after := MapStr{
+		"host":   MapStr{"name": "foo", "ip": "::1"},
+		"bytes":  20,
+		"tags":   []string{"a", "b"},
+		"labels": []interface{}{MapStr{"env": "prod"}},
+		"error":  nil,
+	}
+
+	expected := strings.Join([]string{
+		"~ bytes: 10 -> 20",
+		"+ error: null",
+		"+ host.ip: ::1",
+		`+ labels: [{"env":"prod"}]`,
+		"- status: 200",
+		`~ tags: ["a"] -> ["a","b"]`,
+	}, "\n")
+	assert.Equal(t, expected, before.Diff(after).String())
+	assert.Equal(t, "", before.Diff(before).String())
+}
+
+func TestMapStrEqualsIgnoringNumericType(t *testing.T) {
+	event := MapStr{
+		"count":   42,
+		"bytes":   uint64(1024),
+		"pct":     0.5,
+		"ratio":   0.1,
+		"load":    float32(1.5),
+		"name":    "beat",
+		"ok":      true,
+		"missing": nil,
+		"tags":    []string{"a", "b"},
+		"ports":   []int{80, 443},
+		"host":    MapStr{"id": int64(1), "cpu": float32(0.25)},
+		"users":   []MapStr{{"id": 1}},
+	}
+
+	decoded := MapStr{
+		"count":   42.0,
+		"bytes":   json.Number("1024"),
+		"pct":     json.Number("0.5"),
+		"ratio":   json.Number("0.1"),
+		"load":    json.Number("1.5"),
+		"name":    "beat",
+		"ok":      true,
+		"missing": nil,
+		"tags":    []interface{}{"a", "b"},
+		"ports":   []interface{}{80.0, json.Number("443")},
+		"host":    map[string]interface{}{"id": 1.0, "cpu": 0.25},
+		"users":   []interface{}{map[string]interface{}{"id": 1.0}},
+	}
+
+	assert.True(t, event.EqualsIgnoringNumericType(decoded))
+	assert.True(t, decoded.EqualsIgnoringNumericType(event))
+
+	tests := map[string]struct{ a, b MapStr }{
+		"different number":  {MapStr{"count": 42}, MapStr{"count": 42.5}},
+		"number and string": {MapStr{"count": 42}, MapStr{"count": "42"}},
+		"missing key":       {MapStr{"count": 42}, MapStr{"other": 42}},
+		"slice and number":  {MapStr{"count": 42}, MapStr{"count": []int{42}}},
+		"large integers":    {MapStr{"count": float64(1 << 53)}, MapStr{"count": int64(1<<53 + 1)}},
+		"slice elements":    {MapStr{"a": []int{1, 2}}, MapStr{"a": []float64{1, 3}}},
+		"map and number":    {MapStr{"a": MapStr{}}, MapStr{"a": 1}},
+		"NaN":               {MapStr{"a": math.NaN()}, MapStr{"a": math.NaN()}},
+		"inexact decimals":  {MapStr{"a": 1.1}, MapStr{"a": json.Number("1.2")}},
+	}
+	for name, test := range tests {
+		assert.False(t, test.a.EqualsIgnoringNumericType(test.b), name)
+	}
+
+	assert.True(t, MapStr{}.EqualsIgnoringNumericType(nil))
+}
+
+func TestMapStrErrorCauses(t *testing.T) {
+	m := MapStr{
+		"a": MapStr{
+			"b": "scalar",
+		},
+		"items": []interface{}{MapStr{"name": "x"}},
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		cause error
+		msg   string
+	}{
+		{
+			name:  "missing key",
+			err:   getValueErr(m, "c.d"),
+			cause: ErrKeyNotFound,
+			msg:   "key not found",
+		},
+		{
+			name:  "scalar intermediate",
+			err:   getValueErr(m, "a.b.c"),
+			cause: ErrKeyTypeMismatch,
+			msg:   "expected map at 'a.b' but type is string",
+		},
+		{
+			name:  "non-numeric index",
+			err:   getValueErr(m, "items.x.name"),
+			cause: ErrInvalidPath,
+			msg:   "expected numeric slice index but got 'x'",
+		},
+		{
+			name:  "put into scalar",
+			err:   putErr(m, "a.b.c", 1),
+			cause: ErrKeyTypeMismatch,
+			msg:   "expected map at 'a.b' but type is string",
+		},
+		{
+			name:  "put slice element",
+			err:   putErr(m, "items.0", 1),
+			cause: ErrInvalidPath,
+			msg:   "key addresses a slice element which can not be modified",
+		},
+		{
+			name:  "delete missing key",
+			err:   m.Delete("a.c"),
+			cause: ErrKeyNotFound,
+			msg:   "key not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if assert.Error(t, test.err) {
+				assert.Equal(t, test.cause, errors.Cause(test.err))
+				assert.Equal(t, test.msg, test.err.Error())
+			}
+		})
+	}
+}
+
+func getValueErr(m MapStr, key string) error {
+	_, err := m.GetValue(key)
+	return err
+}
+
+func putErr(m MapStr, key string, value interface{}) error {
+	_, err := m.Put(key, value)
+	return err
+}
+
+func TestMapStrSelect(t *testing.T) {
+	m := MapStr{
+		"message": "hello",
+		"host": MapStr{
+			"name": "a",
+			"ip":   []string{"127.0.0.1"},
+			"os":   MapStr{"name": "linux", "version": "4.0"},
+		},
+		"process": MapStr{
+			"name": "beat",
+			"pid":  1,
+		},
+		"log.level": "info",
+	}
+
+	tests := []struct {
+		name     string
+		keys     []string
+		expected MapStr
+	}{
+		{
+			name:     "plain keys",
+			keys:     []string{"message", "host.os.name", "missing.key"},
+			expected: MapStr{"message": "hello", "host": MapStr{"os": MapStr{"name": "linux"}}},
+		},
+		{
+			name: "map selects subtree",
+			keys: []string{"process"},
+			expected: MapStr{
+				"process": MapStr{"name": "beat", "pid": 1},
+			},
+		},
+		{
+			name: "glob",
+			keys: []string{"*.name"},
+			expected: MapStr{
+				"host":    MapStr{"name": "a", "os": MapStr{"name": "linux"}},
+				"process": MapStr{"name": "beat"},
+			},
+		},
+		{
+			name:     "dotted key",
+			keys:     []string{"log.level"},
+			expected: MapStr{"log.level": "info"},
+		},
+		{
+			name:     "nothing selected",
+			keys:     []string{"other"},
+			expected: MapStr{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, m.Select(test.keys))
+		})
+	}
+
+	selected := m.Select([]string{"host"})
+	selected.Put("host.os.name", "windows")
+	selected["host"].(MapStr)["ip"].([]string)[0] = "::1"
+	assert.Equal(t, "linux", m["host"].(MapStr)["os"].(MapStr)["name"])
+	assert.Equal(t, []string{"127.0.0.1"}, m["host"].(MapStr)["ip"])
+}
+
+func TestMapStrFilterValues(t *testing.T) {
+	newMap := func() MapStr {
+		return MapStr{
+			"message": "hello",
+			"empty":   "",
+			"host": MapStr{
+				"name": "",
+				"os":   map[string]interface{}{"family": nil},
+			},
+			"process": MapStr{
+				"pid":  1,
+				"args": []string{"a"},
+			},
+			"labels": MapStr{},
+		}
+	}
+	m := newMap()
+
+	filtered := m.FilterValues(func(key string, v interface{}) bool {
+		return v != nil && v != ""
+	})
+	assert.Equal(t, MapStr{
+		"message": "hello",
+		"process": MapStr{
+			"pid":  1,
+			"args": []string{"a"},
+		},
+	}, filtered)
+	assert.Equal(t, newMap(), m)
+
+	var keys []string
+	m.FilterValues(func(key string, v interface{}) bool {
+		keys = append(keys, key)
+		return false
+	})
+	sort.Strings(keys)
+	assert.Equal(t, []string{"empty", "host.name", "host.os.family", "message", "process.args", "process.pid"}, keys)
+}
+
+func TestMapStrWalk(t *testing.T) {
+	m := MapStr{
+		"message": "hello",
+		"host": MapStr{
+			"name": "a",
+			"os":   map[string]interface{}{"name": "linux"},
+		},
+		"tags":      []interface{}{"x", MapStr{"y": 1}},
+		"users":     []MapStr{{"id": 1}},
+		"log.level": "info",
+	}
+
+	collect := func(walk func(func(string, interface{}))) map[string]interface{} {
+		values := map[string]interface{}{}
+		walk(func(key string, value interface{}) {
+			values[key] = value
+		})
+		return values
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"message":      "hello",
+		"host.name":    "a",
+		"host.os.name": "linux",
+		"tags":         []interface{}{"x", MapStr{"y": 1}},
+		"users":        []MapStr{{"id": 1}},
+		"log.level":    "info",
+	}, collect(m.Walk))
+
+	assert.Equal(t, map[string]interface{}{
+		"message":      "hello",
+		"host.name":    "a",
+		"host.os.name": "linux",
+		"tags.0":       "x",
+		"tags.1.y":     1,
+		"users.0.id":   1,
+		"log.level":    "info",
+	}, collect(m.WalkSlices))
+}
+
+func TestMapStrTypedGetters(t *testing.T) {
+	m := MapStr{
+		"str":     "hello",
+		"int":     42,
+		"int64":   int64(42),
+		"float":   42.0,
+		"decimal": 42.5,
+		"number":  json.Number("42"),
+		"numstr":  "42",
+		"bool":    true,
+		"nested":  MapStr{"value": 1},
+	}
+
+	s, err := m.GetString("str")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", s)
+
+	for _, key := range []string{"int", "int64", "float", "number", "nested.value"} {
+		i, err := m.GetInt(key)
+		if assert.NoError(t, err, key) {
+			expected := 42
+			if key == "nested.value" {
+				expected = 1
+			}
+			assert.Equal(t, expected, i, key)
+		}
+	}
+
+	for key, expected := range map[string]float64{"int": 42, "float": 42, "decimal": 42.5, "number": 42} {
+		f, err := m.GetFloat(key)
+		if assert.NoError(t, err, key) {
+			assert.Equal(t, expected, f, key)
+		}
+	}
+
+	b, err := m.GetBool("bool")
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	mismatches := map[string]func() error{
+		"string from int":   func() error { _, err := m.GetString("int"); return err },
+		"int from decimal":  func() error { _, err := m.GetInt("decimal"); return err },
+		"int from string":   func() error { _, err := m.GetInt("numstr"); return err },
+		"float from string": func() error { _, err := m.GetFloat("numstr"); return err },
+		"bool from string":  func() error { _, err := m.GetBool("str"); return err },
+	}
+	for name, get := range mismatches {
+		err := get()
+		if assert.Error(t, err, name) {
+			assert.Equal(t, ErrKeyTypeMismatch, errors.Cause(err), name)
+		}
+	}
+
+	_, err = m.GetString("missing")
+	assert.Equal(t, ErrKeyNotFound, errors.Cause(err))
+
+	_, err = m.GetBool("int")
+	assert.EqualError(t, err, "expected bool at 'int' but type is int")
+}
+
+func TestMapStrGetSlice(t *testing.T) {
+	items := []interface{}{MapStr{"name": "a"}, "b"}
+	m := MapStr{
+		"items":   items,
+		"mapstrs": []MapStr{{"name": "a"}},
+		"str":     "hello",
+	}
+
+	s, err := m.GetSlice("items")
+	assert.NoError(t, err)
+	assert.Equal(t, items, s)
+
+	// The slice is not copied
+	s[1] = "c"
+	assert.Equal(t, "c", items[1])
+
+	_, err = m.GetSlice("mapstrs")
+	assert.Equal(t, ErrKeyTypeMismatch, errors.Cause(err))
+
+	_, err = m.GetSlice("str")
+	assert.EqualError(t, err, "expected slice at 'str' but type is string")
+
+	_, err = m.GetSlice("missing")
+	assert.Equal(t, ErrKeyNotFound, errors.Cause(err))
+}
+
+func TestSplitKey(t *testing.T) {
+	tests := map[string][]string{
+		"":                                {""},
+		"a":                               {"a"},
+		"a.b.c":                           {"a", "b", "c"},
+		`host\.name`:                      {"host.name"},
+		`labels.app\.kubernetes\.io/name`: {"labels", "app.kubernetes.io/name"},
+		`path.C:\Windows`:                 {"path", `C:\Windows`},
+		`a\.`:                             {"a."},
+	}
+
+	for key, expected := range tests {
+		assert.Equal(t, expected, SplitKey(key), key)
+	}
+
+	assert.Equal(t, `app\.kubernetes\.io/name`, EscapeKey("app.kubernetes.io/name"))
+	assert.Equal(t, []string{"labels", "app.kubernetes.io/name"}, SplitKey("labels."+EscapeKey("app.kubernetes.io/name")))
+}
+
+func TestMapStrEscapedKeys(t *testing.T) {
+	m := MapStr{
+		"kubernetes": MapStr{
+			"labels": MapStr{
+				"app.kubernetes.io/name": "beat",
+				"app": MapStr{
+					"kubernetes": "nested",
+				},
+			},
+		},
+	}
+
+	v, err := m.GetValue(`kubernetes.labels.app\.kubernetes\.io/name`)
+	assert.NoError(t, err)
+	assert.Equal(t, "beat", v)
+
+	// Unescaped dots still separate segments
+	v, err = m.GetValue("kubernetes.labels.app.kubernetes")
+	assert.NoError(t, err)
+	assert.Equal(t, "nested", v)
+
+	old, err := m.Put(`kubernetes.labels.app\.kubernetes\.io/version`, "1.0")
+	assert.NoError(t, err)
+	assert.Nil(t, old)
+	assert.Equal(t, "1.0", m["kubernetes"].(MapStr)["labels"].(MapStr)["app.kubernetes.io/version"])
+
+	_, err = m.Put(`host\.name`, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", m["host.name"])
+
+	assert.True(t, m.HasKeyPath(`kubernetes.labels.app\.kubernetes\.io/name`))
+	assert.NoError(t, m.Delete(`kubernetes.labels.app\.kubernetes\.io/name`))
+	assert.False(t, m.HasKeyPath(`kubernetes.labels.app\.kubernetes\.io/name`))
+}
+
+func TestFromFlattened(t *testing.T) {
+	nested := MapStr{
+		"host": MapStr{
+			"name": "server",
+			"os":   MapStr{"family": "linux", "version": 10},
+		},
+		"tags":    []interface{}{"a", MapStr{"b": 1}},
+		"message": "hello",
+	}
+	expanded, err := FromFlattened(nested.Flatten())
+	assert.NoError(t, err)
+	assert.Equal(t, nested, expanded)
+
+	input := MapStr{
+		"a":                map[string]interface{}{"b": 1},
+		"a.c":              2,
+		`labels.app\.name`: "beat",
+	}
+	expanded, err = FromFlattened(input)
+	assert.NoError(t, err)
+	assert.Equal(t, MapStr{
+		"a":      MapStr{"b": 1, "c": 2},
+		"labels": MapStr{"app.name": "beat"},
+	}, expanded)
+	assert.Equal(t, map[string]interface{}{"b": 1}, input["a"])
+
+	_, err = FromFlattened(MapStr{"a": 1, "a.b.c": 2})
+	assert.EqualError(t, err, "key 'a.b.c' conflicts with the value of 'a'")
+	assert.Equal(t, ErrKeyTypeMismatch, errors.Cause(err))
+
+	_, err = FromFlattened(MapStr{"a": MapStr{"b": 1}, "a.b": 2})
+	assert.EqualError(t, err, "key 'a.b' is defined more than once")
+
+	expanded, err = FromFlattened(MapStr{})
+	assert.NoError(t, err)
+	assert.Equal(t, MapStr{}, expanded)
+}
@@ -37,18 +37,17 @@ const alternativeKey = "value"
 //
 // Put detects this scenario and renames the common base key, by appending
 // `.value`
+//
+// As in `common.MapStr.Put`, dots escaped with a backslash are part of the key
+// segment, see common.SplitKey. Unlike `common.MapStr.Put`, numeric segments
+// don't index into slices: a slice found on the path is handled like any other
+// value that is not a map, and stored under `value` in a new map.
 func Put(data common.MapStr, key string, value interface{}) error {
-	// XXX This implementation mimics `common.MapStr.Put`, both should be updated to have similar behavior
-
 	d, k := mapFind(data, key, alternativeKey)
 	d[k] = value
 	return nil
 }
 
-// mapFind walk the map based on the given dotted key and returns the final map
-// and key to operate on. This function adds intermediate maps, if the key is
-// missing from the original map.
-
 // mapFind iterates a MapStr based on the given dotted key, finding the final
 // subMap and subKey to operate on.
 // If a key is already used, but the used value is no map, an intermediate map will be inserted and
@@ -56,31 +55,23 @@ func Put(data common.MapStr, key string, value interface{}) error {
 // If the old value found under key is already an dictionary, subMap will be
 // the old value and subKey will be set to alternativeKey.
 func mapFind(data common.MapStr, key, alternativeKey string) (subMap common.MapStr, subKey string) {
-	// XXX This implementation mimics `common.mapFind`, both should be updated to have similar behavior
-
+	segments := common.SplitKey(key)
 	for {
-		if oldValue, exists := data[key]; exists {
+		// Check if the rest of the key is used as is.
+		rest := joinKey(segments)
+		if oldValue, exists := data[rest]; exists {
 			if oldMap, ok := tryToMapStr(oldValue); ok {
 				return oldMap, alternativeKey
 			}
-			return data, key
+			return data, rest
 		}
 
-		idx := strings.IndexRune(key, '.')
-		if idx < 0 {
-			// if old value exists and is a dictionary, return the old dictionary and
-			// make sure we store the new value using the 'alternativeKey'
-			if oldValue, exists := data[key]; exists {
-				if oldMap, ok := tryToMapStr(oldValue); ok {
-					return oldMap, alternativeKey
-				}
-			}
-
-			return data, key
+		if len(segments) == 1 {
+			return data, segments[0]
 		}
 
 		// Check if first sub-key exists. Create an intermediate map if not.
-		k := key[:idx]
+		k := segments[0]
 		d, exists := data[k]
 		if !exists {
 			d = common.MapStr{}
@@ -96,11 +87,24 @@ func mapFind(data common.MapStr, key, alternativeKey string) (subMap common.MapS
 		}
 
 		// advance into sub-map
-		key = key[idx+1:]
+		segments = segments[1:]
 		data = v
 	}
 }
 
+// joinKey joins the segments into a dotted key, escaping the dots they
+// contain.
+func joinKey(segments []string) string {
+	if len(segments) == 1 {
+		return segments[0]
+	}
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = common.EscapeKey(segment)
+	}
+	return strings.Join(escaped, ".")
+}
+
 func tryToMapStr(v interface{}) (common.MapStr, bool) {
 	switch m := v.(type) {
 	case common.MapStr:
@@ -80,3 +80,28 @@ func TestPutRenames(t *testing.T) {
 			"value": "x",
 		}}}}}, b)
 }
+
+func TestPutEscapedDots(t *testing.T) {
+	m := common.MapStr{}
+	err := Put(m, `labels.app\.kubernetes\.io/name`, "nginx")
+	assert.NoError(t, err)
+	err = Put(m, `labels.app\.kubernetes\.io/name.version`, "1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, common.MapStr{"labels": common.MapStr{
+		"app.kubernetes.io/name": common.MapStr{
+			"value":   "nginx",
+			"version": "1.0",
+		},
+	}}, m)
+}
+
+func TestPutSlice(t *testing.T) {
+	// Slices are not indexed, they are kept under the alternative key.
+	m := common.MapStr{"tags": []string{"a", "b"}}
+	err := Put(m, "tags.0", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, common.MapStr{"tags": common.MapStr{
+		"value": []string{"a", "b"},
+		"0":     "c",
+	}}, m)
+}
@@ -30,7 +30,7 @@ type Processor struct {
 }
 
 var (
-	defaultScalingFactor = 1000
+	defaultScalingFactor = 1000.0
 	defaultIgnoreAbove   = 1024
 )
 
@@ -44,6 +44,7 @@ func (p *Processor) Process(fields common.Fields, path string, output common.Map
 			continue
 		}
 
+		field = field.ForVersion(p.EsVersion.Major)
 		field.Path = path
 		var mapping common.MapStr
 
@@ -66,7 +67,15 @@ func (p *Processor) Process(fields common.Fields, path string, output common.Map
 			mapping = p.array(&field)
 		case "alias":
 			mapping = p.alias(&field)
+		case "date":
+			mapping = p.date(&field)
 		case "group":
+			if field.Enabled != nil && !*field.Enabled {
+				// Subtree is not indexed, children are ignored
+				mapping = common.MapStr{"enabled": false}
+				break
+			}
+
 			var newPath string
 			if path == "" {
 				newPath = field.Name
@@ -74,7 +83,7 @@ func (p *Processor) Process(fields common.Fields, path string, output common.Map
 				newPath = path + "." + field.Name
 			}
 			mapping = common.MapStr{}
-			if field.Dynamic.Value != nil {
+			if field.Dynamic.IsSet() {
 				mapping["dynamic"] = field.Dynamic.Value
 			}
 
@@ -99,6 +108,8 @@ func (p *Processor) Process(fields common.Fields, path string, output common.Map
 			mapping = p.other(&field)
 		}
 
+		p.addTimeSeries(&field, mapping)
+
 		if len(mapping) > 0 {
 			output.Put(common.GenerateKey(field.Name), mapping)
 		}
@@ -137,7 +148,7 @@ func (p *Processor) scaledFloat(f *common.Field, params ...common.MapStr) common
 	}
 
 	if len(params) > 0 {
-		if s, ok := params[0][scalingFactorKey].(int); ok && s != 0 {
+		if s, ok := params[0][scalingFactorKey].(float64); ok && s != 0 {
 			scalingFactor = s
 		}
 	}
@@ -165,6 +176,7 @@ func (p *Processor) ip(f *common.Field) common.MapStr {
 		property["type"] = "string"
 		property["ignore_above"] = 1024
 		property["index"] = "not_analyzed"
+		delete(property, "ignore_malformed")
 	}
 	return property
 }
@@ -177,7 +189,7 @@ func (p *Processor) keyword(f *common.Field) common.MapStr {
 		fullName = f.Path + "." + f.Name
 	}
 
-	if f.Index == nil || (f.Index != nil && *f.Index) {
+	if f.IsDefaultField() {
 		defaultFields = append(defaultFields, fullName)
 	}
 
@@ -194,8 +206,21 @@ func (p *Processor) keyword(f *common.Field) common.MapStr {
 	if p.EsVersion.IsMajor(2) {
 		property["type"] = "string"
 		property["index"] = "not_analyzed"
+		if f.Index != nil && !*f.Index {
+			property["index"] = "no"
+		}
 	}
 
+	if f.Norms != nil {
+		if p.EsVersion.IsMajor(2) {
+			property["norms"] = common.MapStr{"enabled": *f.Norms}
+		} else {
+			property["norms"] = *f.Norms
+		}
+	}
+
+	p.addBoost(f, property)
+
 	if len(f.MultiFields) > 0 {
 		fields := common.MapStr{}
 		p.Process(f.MultiFields, "", fields)
@@ -213,7 +238,7 @@ func (p *Processor) text(f *common.Field) common.MapStr {
 		fullName = f.Path + "." + f.Name
 	}
 
-	if f.Index == nil || (f.Index != nil && *f.Index) {
+	if f.IsDefaultField() {
 		defaultFields = append(defaultFields, fullName)
 	}
 
@@ -222,13 +247,16 @@ func (p *Processor) text(f *common.Field) common.MapStr {
 	if p.EsVersion.IsMajor(2) {
 		properties["type"] = "string"
 		properties["index"] = "analyzed"
-		if !f.Norms {
+		if f.Index != nil && !*f.Index {
+			properties["index"] = "no"
+		}
+		if f.Norms == nil || !*f.Norms {
 			properties["norms"] = common.MapStr{
 				"enabled": false,
 			}
 		}
 	} else {
-		if !f.Norms {
+		if f.Norms == nil || !*f.Norms {
 			properties["norms"] = false
 		}
 	}
@@ -241,6 +269,16 @@ func (p *Processor) text(f *common.Field) common.MapStr {
 		properties["search_analyzer"] = f.SearchAnalyzer
 	}
 
+	p.addBoost(f, properties)
+
+	if f.Similarity != "" {
+		properties["similarity"] = f.Similarity
+	}
+
+	if f.TermVector != "" {
+		properties["term_vector"] = f.TermVector
+	}
+
 	if len(f.MultiFields) > 0 {
 		fields := common.MapStr{}
 		p.Process(f.MultiFields, "", fields)
@@ -250,6 +288,28 @@ func (p *Processor) text(f *common.Field) common.MapStr {
 	return properties
 }
 
+// addBoost sets the index-time boost of the field. Elasticsearch 8.0 rejects
+// it for new indices, so it is left out of mappings for newer versions.
+func (p *Processor) addBoost(f *common.Field, properties common.MapStr) {
+	if f.Boost != nil && p.EsVersion.LessThan(common.MustNewVersion("8.0.0")) {
+		properties["boost"] = *f.Boost
+	}
+}
+
+// addTimeSeries sets the time series settings of the field, they are only
+// supported by Elasticsearch 8.0 and later.
+func (p *Processor) addTimeSeries(f *common.Field, properties common.MapStr) {
+	if len(properties) == 0 || p.EsVersion.LessThan(common.MustNewVersion("8.0.0")) {
+		return
+	}
+	if f.TimeSeriesMetric != "" {
+		properties["time_series_metric"] = f.TimeSeriesMetric
+	}
+	if f.TimeSeriesDimension != nil {
+		properties["time_series_dimension"] = *f.TimeSeriesDimension
+	}
+}
+
 func (p *Processor) array(f *common.Field) common.MapStr {
 	properties := getDefaultProperties(f)
 	if f.ObjectType != "" {
@@ -258,6 +318,15 @@ func (p *Processor) array(f *common.Field) common.MapStr {
 	return properties
 }
 
+func (p *Processor) date(f *common.Field) common.MapStr {
+	property := getDefaultProperties(f)
+	property["type"] = "date"
+	if f.DateFormat != "" {
+		property["format"] = f.DateFormat
+	}
+	return property
+}
+
 func (p *Processor) alias(f *common.Field) common.MapStr {
 	// Aliases were introduced in Elasticsearch 6.4, ignore if unsupported
 	if p.EsVersion.LessThan(common.MustNewVersion("6.4.0")) {
@@ -271,28 +340,13 @@ func (p *Processor) alias(f *common.Field) common.MapStr {
 }
 
 func (p *Processor) object(f *common.Field) common.MapStr {
-	matchType := func(onlyType string, mt string) string {
-		if mt != "" {
-			return mt
-		}
-		return onlyType
-	}
-
-	var otParams []common.ObjectTypeCfg
-	if len(f.ObjectTypeParams) != 0 {
-		otParams = f.ObjectTypeParams
-	} else {
-		otParams = []common.ObjectTypeCfg{common.ObjectTypeCfg{
-			ObjectType: f.ObjectType, ObjectTypeMappingType: f.ObjectTypeMappingType, ScalingFactor: f.ScalingFactor}}
-	}
-
-	for _, otp := range otParams {
+	for _, otp := range f.ObjectTypeConfigs() {
 		dynProperties := getDefaultProperties(f)
 
 		switch otp.ObjectType {
 		case "scaled_float":
 			dynProperties = p.scaledFloat(f, common.MapStr{scalingFactorKey: otp.ScalingFactor})
-			addDynamicTemplate(f, dynProperties, matchType("*", otp.ObjectTypeMappingType))
+			addDynamicTemplate(f, dynProperties, otp)
 		case "text":
 			dynProperties["type"] = "text"
 
@@ -300,13 +354,13 @@ func (p *Processor) object(f *common.Field) common.MapStr {
 				dynProperties["type"] = "string"
 				dynProperties["index"] = "analyzed"
 			}
-			addDynamicTemplate(f, dynProperties, matchType("string", otp.ObjectTypeMappingType))
+			addDynamicTemplate(f, dynProperties, otp)
 		case "keyword":
 			dynProperties["type"] = otp.ObjectType
-			addDynamicTemplate(f, dynProperties, matchType("string", otp.ObjectTypeMappingType))
+			addDynamicTemplate(f, dynProperties, otp)
 		case "byte", "double", "float", "long", "short", "boolean":
 			dynProperties["type"] = otp.ObjectType
-			addDynamicTemplate(f, dynProperties, matchType(otp.ObjectType, otp.ObjectTypeMappingType))
+			addDynamicTemplate(f, dynProperties, otp)
 		}
 	}
 
@@ -316,29 +370,34 @@ func (p *Processor) object(f *common.Field) common.MapStr {
 		properties["enabled"] = *f.Enabled
 	}
 
-	if f.Dynamic.Value != nil {
+	if f.Dynamic.IsSet() {
 		properties["dynamic"] = f.Dynamic.Value
 	}
 
 	return properties
 }
 
-func addDynamicTemplate(f *common.Field, properties common.MapStr, matchType string) {
+func addDynamicTemplate(f *common.Field, properties common.MapStr, otp common.ObjectTypeCfg) {
 	path := ""
 	if len(f.Path) > 0 {
 		path = f.Path + "."
 	}
 	pathMatch := path + f.Name
-	if !strings.ContainsRune(pathMatch, '*') {
+	if otp.MatchPattern != "" {
+		pathMatch += "." + otp.MatchPattern
+	} else if !strings.ContainsRune(pathMatch, '*') {
 		pathMatch += ".*"
 	}
+	dynTemplate := common.MapStr{
+		"mapping":    properties,
+		"path_match": pathMatch,
+	}
+	if matchType := otp.MatchMappingType(); matchType != "" {
+		dynTemplate["match_mapping_type"] = matchType
+	}
 	template := common.MapStr{
 		// Set the path of the field as name
-		path + f.Name: common.MapStr{
-			"mapping":            properties,
-			"match_mapping_type": matchType,
-			"path_match":         pathMatch,
-		},
+		path + f.Name: dynTemplate,
 	}
 
 	dynamicTemplates = append(dynamicTemplates, template)
@@ -356,7 +415,26 @@ func getDefaultProperties(f *common.Field) common.MapStr {
 		properties["doc_values"] = *f.DocValues
 	}
 
-	if f.CopyTo != "" {
+	if f.Coerce != nil {
+		switch f.Type {
+		case "long", "integer", "short", "byte", "double", "float", "half_float", "scaled_float":
+			properties["coerce"] = *f.Coerce
+		}
+	}
+
+	if f.IgnoreMalformed != nil {
+		switch f.Type {
+		case "long", "integer", "short", "byte", "double", "float", "half_float", "scaled_float",
+			"date", "ip", "geo_point", "geo_shape":
+			properties["ignore_malformed"] = *f.IgnoreMalformed
+		}
+	}
+
+	switch len(f.CopyTo) {
+	case 0:
+	case 1:
+		properties["copy_to"] = f.CopyTo[0]
+	default:
 		properties["copy_to"] = f.CopyTo
 	}
 	return properties
@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/beats/libbeat/common"
 )
@@ -32,6 +33,8 @@ func TestProcessor(t *testing.T) {
 	pEsVersion2 := &Processor{EsVersion: *common.MustNewVersion("2.0.0")}
 	pEsVersion64 := &Processor{EsVersion: *common.MustNewVersion("6.4.0")}
 	pEsVersion63 := &Processor{EsVersion: *common.MustNewVersion("6.3.6")}
+	pEsVersion8 := &Processor{EsVersion: *common.MustNewVersion("8.0.0")}
+	boost := 2.5
 
 	tests := []struct {
 		output   common.MapStr
@@ -45,35 +48,35 @@ func TestProcessor(t *testing.T) {
 			output: p.scaledFloat(&common.Field{Type: "scaled_float"}),
 			expected: common.MapStr{
 				"type":           "scaled_float",
-				"scaling_factor": 1000,
+				"scaling_factor": 1000.0,
 			},
 		},
 		{
 			output: p.scaledFloat(&common.Field{Type: "scaled_float", ScalingFactor: 100}),
 			expected: common.MapStr{
 				"type":           "scaled_float",
-				"scaling_factor": 100,
+				"scaling_factor": 100.0,
 			},
 		},
 		{
-			output: p.scaledFloat(&common.Field{Type: "scaled_float"}, common.MapStr{scalingFactorKey: 0}),
+			output: p.scaledFloat(&common.Field{Type: "scaled_float"}, common.MapStr{scalingFactorKey: 0.0}),
 			expected: common.MapStr{
 				"type":           "scaled_float",
-				"scaling_factor": 1000,
+				"scaling_factor": 1000.0,
 			},
 		},
 		{
 			output: p.scaledFloat(&common.Field{Type: "scaled_float"}, common.MapStr{"someKey": 10}),
 			expected: common.MapStr{
 				"type":           "scaled_float",
-				"scaling_factor": 1000,
+				"scaling_factor": 1000.0,
 			},
 		},
 		{
-			output: p.scaledFloat(&common.Field{Type: "scaled_float"}, common.MapStr{scalingFactorKey: 10}),
+			output: p.scaledFloat(&common.Field{Type: "scaled_float"}, common.MapStr{scalingFactorKey: 10.0}),
 			expected: common.MapStr{
 				"type":           "scaled_float",
-				"scaling_factor": 10,
+				"scaling_factor": 10.0,
 			},
 		},
 		{
@@ -88,12 +91,20 @@ func TestProcessor(t *testing.T) {
 			},
 		},
 		{
-			output: p.integer(&common.Field{Type: "long", CopyTo: "hello.world"}),
+			output: p.integer(&common.Field{Type: "long", CopyTo: []string{"hello.world"}}),
 			expected: common.MapStr{
 				"type":    "long",
 				"copy_to": "hello.world",
 			},
 		},
+		{
+			output: p.keyword(&common.Field{Type: "keyword", CopyTo: []string{"all", "hello.world"}}),
+			expected: common.MapStr{
+				"type":         "keyword",
+				"ignore_above": 1024,
+				"copy_to":      []string{"all", "hello.world"},
+			},
+		},
 		{
 			output:   p.array(&common.Field{Type: "array"}),
 			expected: common.MapStr{},
@@ -131,21 +142,21 @@ func TestProcessor(t *testing.T) {
 			},
 		},
 		{
-			output: p.text(&common.Field{Type: "text", Analyzer: "autocomplete", Norms: true}),
+			output: p.text(&common.Field{Type: "text", Analyzer: "autocomplete", Norms: &trueVar}),
 			expected: common.MapStr{
 				"type":     "text",
 				"analyzer": "autocomplete",
 			},
 		},
 		{
-			output: p.text(&common.Field{Type: "text", SearchAnalyzer: "standard", Norms: true}),
+			output: p.text(&common.Field{Type: "text", SearchAnalyzer: "standard", Norms: &trueVar}),
 			expected: common.MapStr{
 				"type":            "text",
 				"search_analyzer": "standard",
 			},
 		},
 		{
-			output: p.text(&common.Field{Type: "text", Analyzer: "autocomplete", SearchAnalyzer: "standard", Norms: true}),
+			output: p.text(&common.Field{Type: "text", Analyzer: "autocomplete", SearchAnalyzer: "standard", Norms: &trueVar}),
 			expected: common.MapStr{
 				"type":            "text",
 				"analyzer":        "autocomplete",
@@ -153,7 +164,88 @@ func TestProcessor(t *testing.T) {
 			},
 		},
 		{
-			output: p.text(&common.Field{Type: "text", MultiFields: common.Fields{common.Field{Name: "raw", Type: "keyword"}}, Norms: true}),
+			output: p.integer(&common.Field{Type: "integer", Coerce: &falseVar}),
+			expected: common.MapStr{
+				"type":   "long",
+				"coerce": false,
+			},
+		},
+		{
+			output: p.scaledFloat(&common.Field{Type: "scaled_float", Coerce: &trueVar}),
+			expected: common.MapStr{
+				"type":           "scaled_float",
+				"scaling_factor": 1000.0,
+				"coerce":         true,
+			},
+		},
+		{
+			output: p.other(&common.Field{Type: "long", Coerce: &falseVar}),
+			expected: common.MapStr{
+				"type":   "long",
+				"coerce": false,
+			},
+		},
+		{
+			output: p.keyword(&common.Field{Type: "keyword", Boost: &boost}),
+			expected: common.MapStr{
+				"type":         "keyword",
+				"ignore_above": 1024,
+				"boost":        2.5,
+			},
+		},
+		{
+			output: p.text(&common.Field{Type: "text", Boost: &boost}),
+			expected: common.MapStr{
+				"type":  "text",
+				"norms": false,
+				"boost": 2.5,
+			},
+		},
+		{
+			output: pEsVersion8.keyword(&common.Field{Type: "keyword", Boost: &boost}),
+			expected: common.MapStr{
+				"type":         "keyword",
+				"ignore_above": 1024,
+			},
+		},
+		{
+			output: p.date(&common.Field{Type: "date", IgnoreMalformed: &trueVar}),
+			expected: common.MapStr{
+				"type":             "date",
+				"ignore_malformed": true,
+			},
+		},
+		{
+			output: p.other(&common.Field{Type: "geo_point", IgnoreMalformed: &falseVar}),
+			expected: common.MapStr{
+				"type":             "geo_point",
+				"ignore_malformed": false,
+			},
+		},
+		{
+			output: p.keyword(&common.Field{Type: "keyword", IgnoreMalformed: &trueVar}),
+			expected: common.MapStr{
+				"type":         "keyword",
+				"ignore_above": 1024,
+			},
+		},
+		{
+			output: p.keyword(&common.Field{Type: "keyword", Coerce: &falseVar}),
+			expected: common.MapStr{
+				"type":         "keyword",
+				"ignore_above": 1024,
+			},
+		},
+		{
+			output: p.text(&common.Field{Type: "text", Similarity: "boolean", TermVector: "with_positions_offsets", Norms: &trueVar}),
+			expected: common.MapStr{
+				"type":        "text",
+				"similarity":  "boolean",
+				"term_vector": "with_positions_offsets",
+			},
+		},
+		{
+			output: p.text(&common.Field{Type: "text", MultiFields: common.Fields{common.Field{Name: "raw", Type: "keyword"}}, Norms: &trueVar}),
 			expected: common.MapStr{
 				"type": "text",
 				"fields": common.MapStr{
@@ -165,7 +257,7 @@ func TestProcessor(t *testing.T) {
 			},
 		},
 		{
-			output: p.keyword(&common.Field{Type: "keyword", MultiFields: common.Fields{common.Field{Name: "analyzed", Type: "text", Norms: true}}}),
+			output: p.keyword(&common.Field{Type: "keyword", MultiFields: common.Fields{common.Field{Name: "analyzed", Type: "text", Norms: &trueVar}}}),
 			expected: common.MapStr{
 				"type":         "keyword",
 				"ignore_above": 1024,
@@ -176,6 +268,42 @@ func TestProcessor(t *testing.T) {
 				},
 			},
 		},
+		{
+			output: p.keyword(&common.Field{Type: "keyword", Norms: &trueVar, DocValues: &falseVar}),
+			expected: common.MapStr{
+				"type":         "keyword",
+				"ignore_above": 1024,
+				"norms":        true,
+				"doc_values":   false,
+			},
+		},
+		{
+			output: pEsVersion2.keyword(&common.Field{Type: "keyword", Norms: &falseVar}),
+			expected: common.MapStr{
+				"type":         "string",
+				"index":        "not_analyzed",
+				"ignore_above": 1024,
+				"norms":        common.MapStr{"enabled": false},
+			},
+		},
+		{
+			output: p.text(&common.Field{Type: "text", Norms: &falseVar}),
+			expected: common.MapStr{
+				"type":  "text",
+				"norms": false,
+			},
+		},
+		{
+			output:   p.date(&common.Field{Type: "date"}),
+			expected: common.MapStr{"type": "date"},
+		},
+		{
+			output: p.date(&common.Field{Type: "date", DateFormat: "strict_date_optional_time||epoch_millis"}),
+			expected: common.MapStr{
+				"type":   "date",
+				"format": "strict_date_optional_time||epoch_millis",
+			},
+		},
 		{
 			output: p.keyword(&common.Field{Type: "keyword", IgnoreAbove: 256}),
 			expected: common.MapStr{
@@ -200,7 +328,7 @@ func TestProcessor(t *testing.T) {
 			output: p.text(&common.Field{Type: "text", MultiFields: common.Fields{
 				common.Field{Name: "raw", Type: "keyword"},
 				common.Field{Name: "indexed", Type: "text"},
-			}, Norms: true}),
+			}, Norms: &trueVar}),
 			expected: common.MapStr{
 				"type": "text",
 				"fields": common.MapStr{
@@ -219,7 +347,7 @@ func TestProcessor(t *testing.T) {
 			output: p.text(&common.Field{Type: "text", MultiFields: common.Fields{
 				common.Field{Name: "raw", Type: "keyword"},
 				common.Field{Name: "indexed", Type: "text"},
-			}, Norms: true}),
+			}, Norms: &trueVar}),
 			expected: common.MapStr{
 				"type": "text",
 				"fields": common.MapStr{
@@ -258,6 +386,24 @@ func TestProcessor(t *testing.T) {
 				"type": "long", "index": false,
 			},
 		},
+		{
+			output: p.text(&common.Field{Type: "text", Index: &falseVar}),
+			expected: common.MapStr{
+				"type": "text", "index": false, "norms": false,
+			},
+		},
+		{
+			output: pEsVersion2.text(&common.Field{Type: "text", Index: &falseVar}),
+			expected: common.MapStr{
+				"type": "string", "index": "no", "norms": common.MapStr{"enabled": false},
+			},
+		},
+		{
+			output: pEsVersion2.keyword(&common.Field{Type: "keyword", Index: &falseVar}),
+			expected: common.MapStr{
+				"type": "string", "index": "no", "ignore_above": 1024,
+			},
+		},
 		{
 			output: p.other(&common.Field{Type: "text", Index: &trueVar}),
 			expected: common.MapStr{
@@ -398,7 +544,7 @@ func TestDynamicTemplates(t *testing.T) {
 					"core.*.pct": common.MapStr{
 						"mapping": common.MapStr{
 							"type":           "scaled_float",
-							"scaling_factor": 100,
+							"scaling_factor": 100.0,
 						},
 						"match_mapping_type": "float",
 						"path_match":         "core.*.pct",
@@ -432,13 +578,37 @@ func TestDynamicTemplates(t *testing.T) {
 				},
 				common.MapStr{
 					"context": common.MapStr{
-						"mapping":            common.MapStr{"type": "scaled_float", "scaling_factor": 10000},
+						"mapping":            common.MapStr{"type": "scaled_float", "scaling_factor": 10000.0},
 						"match_mapping_type": "*",
 						"path_match":         "context.*",
 					},
 				},
 			},
 		},
+		{
+			field: common.Field{
+				Type: "object", Name: "metrics",
+				ObjectTypeParams: []common.ObjectTypeCfg{
+					{ObjectType: "scaled_float", MatchPattern: "*.pct"},
+					{ObjectType: "long", ObjectTypeMappingType: "long", MatchPattern: "*.bytes"},
+				},
+			},
+			expected: []common.MapStr{
+				common.MapStr{
+					"metrics": common.MapStr{
+						"mapping":    common.MapStr{"type": "scaled_float", "scaling_factor": defaultScalingFactor},
+						"path_match": "metrics.*.pct",
+					},
+				},
+				common.MapStr{
+					"metrics": common.MapStr{
+						"mapping":            common.MapStr{"type": "long"},
+						"match_mapping_type": "long",
+						"path_match":         "metrics.*.bytes",
+					},
+				},
+			},
+		},
 	}
 
 	for _, numericType := range []string{"byte", "double", "float", "long", "short", "boolean"} {
@@ -571,3 +741,107 @@ func TestProcessNoName(t *testing.T) {
 
 	assert.Equal(t, expectedOutput, output)
 }
+
+func TestDefaultFields(t *testing.T) {
+	falseVar := false
+	fields := common.Fields{
+		common.Field{Name: "a", Type: "keyword"},
+		common.Field{Name: "b", Type: "text", DefaultField: &falseVar},
+		common.Field{Name: "c", Type: "group", Fields: common.Fields{
+			common.Field{Name: "d", Type: "text"},
+			common.Field{Name: "e", Type: "keyword", Index: &falseVar},
+			common.Field{Name: "f", Type: "long"},
+		}},
+	}
+
+	defaultFields = nil
+	p := &Processor{}
+	err := p.Process(fields, "", common.MapStr{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c.d"}, defaultFields)
+	assert.Equal(t, defaultFields, fields.DefaultFields())
+}
+
+func TestProcessDisabledGroup(t *testing.T) {
+	falseVar := false
+	fields := common.Fields{
+		common.Field{
+			Name:    "raw",
+			Type:    "group",
+			Enabled: &falseVar,
+			Fields: common.Fields{
+				common.Field{Name: "body", Type: "text"},
+			},
+		},
+		common.Field{
+			Name: "parsed",
+			Type: "group",
+			Fields: common.Fields{
+				common.Field{Name: "body", Type: "text"},
+			},
+		},
+	}
+
+	output := common.MapStr{}
+	p := Processor{EsVersion: *common.MustNewVersion("6.0.0")}
+	require.NoError(t, p.Process(fields, "", output))
+
+	assert.Equal(t, common.MapStr{
+		"raw": common.MapStr{"enabled": false},
+		"parsed": common.MapStr{
+			"properties": common.MapStr{
+				"body": common.MapStr{"type": "text", "norms": false},
+			},
+		},
+	}, output)
+}
+
+func TestProcessGeoPoint(t *testing.T) {
+	fields := common.Fields{
+		common.Field{
+			Name: "location",
+			Type: "geo_point",
+			Fields: common.Fields{
+				common.Field{Name: "lat", Type: "float"},
+				common.Field{Name: "lon", Type: "float"},
+			},
+		},
+	}
+
+	output := common.MapStr{}
+	p := Processor{EsVersion: *common.MustNewVersion("6.0.0")}
+	require.NoError(t, p.Process(fields, "", output))
+
+	assert.Equal(t, common.MapStr{
+		"location": common.MapStr{"type": "geo_point"},
+	}, output)
+}
+
+func TestProcessTimeSeries(t *testing.T) {
+	trueVar := true
+	fields := common.Fields{
+		common.Field{Name: "pod", Type: "keyword", TimeSeriesDimension: &trueVar},
+		common.Field{Name: "requests", Type: "long", TimeSeriesMetric: "counter"},
+		common.Field{Name: "cpu", Type: "scaled_float", TimeSeriesMetric: "gauge"},
+	}
+
+	output := common.MapStr{}
+	p := Processor{EsVersion: *common.MustNewVersion("8.0.0")}
+	require.NoError(t, p.Process(fields, "", output))
+
+	assert.Equal(t, common.MapStr{
+		"pod":      common.MapStr{"type": "keyword", "ignore_above": 1024, "time_series_dimension": true},
+		"requests": common.MapStr{"type": "long", "time_series_metric": "counter"},
+		"cpu":      common.MapStr{"type": "scaled_float", "scaling_factor": 1000.0, "time_series_metric": "gauge"},
+	}, output)
+
+	output = common.MapStr{}
+	p = Processor{EsVersion: *common.MustNewVersion("7.10.0")}
+	require.NoError(t, p.Process(fields, "", output))
+
+	assert.Equal(t, common.MapStr{
+		"pod":      common.MapStr{"type": "keyword", "ignore_above": 1024},
+		"requests": common.MapStr{"type": "long"},
+		"cpu":      common.MapStr{"type": "scaled_float", "scaling_factor": 1000.0},
+	}, output)
+}
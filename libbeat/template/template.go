@@ -39,6 +39,9 @@ var (
 	dynamicTemplates []common.MapStr
 
 	defaultFields []string
+
+	// Lock guarding dynamicTemplates and defaultFields while fields are processed
+	processMutex sync.Mutex
 )
 
 type Template struct {
@@ -114,6 +117,8 @@ func (t *Template) load(fields common.Fields) (common.MapStr, error) {
 	// Locking to make sure dynamicTemplates and defaultFields is not accessed in parallel
 	t.Lock()
 	defer t.Unlock()
+	processMutex.Lock()
+	defer processMutex.Unlock()
 
 	dynamicTemplates = nil
 	defaultFields = nil
@@ -138,6 +143,55 @@ func (t *Template) load(fields common.Fields) (common.MapStr, error) {
 	return output, nil
 }
 
+// GenerateMapping generates the Elasticsearch mapping for the given fields
+// and Elasticsearch version. The mapping contains the properties of all fields
+// and the dynamic templates generated for object fields, in the order the
+// fields are defined. The version selects between the string and the
+// text/keyword types (Elasticsearch 2.x and 5.x+).
+func GenerateMapping(fields common.Fields, esVersion string) (common.MapStr, error) {
+	properties, templates, err := generateMapping(fields, esVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := common.MapStr{
+		"properties": properties,
+	}
+	if len(templates) > 0 {
+		mapping["dynamic_templates"] = templates
+	}
+	return mapping, nil
+}
+
+// DynamicTemplates generates the Elasticsearch dynamic templates for the
+// object fields with object type configurations, in the order the fields and
+// their configurations are defined. Each template is named after the field
+// and matches the configured object_type_mapping_type.
+func DynamicTemplates(fields common.Fields, esVersion string) ([]common.MapStr, error) {
+	_, templates, err := generateMapping(fields, esVersion)
+	return templates, err
+}
+
+func generateMapping(fields common.Fields, esVersion string) (common.MapStr, []common.MapStr, error) {
+	version, err := common.NewVersion(esVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	processMutex.Lock()
+	defer processMutex.Unlock()
+
+	dynamicTemplates = nil
+	defaultFields = nil
+
+	properties := common.MapStr{}
+	processor := Processor{EsVersion: *version}
+	if err := processor.Process(fields, "", properties); err != nil {
+		return nil, nil, err
+	}
+	return properties, dynamicTemplates, nil
+}
+
 // LoadFile loads the the template from the given file path
 func (t *Template) LoadFile(file string) (common.MapStr, error) {
 
@@ -278,7 +332,9 @@ func loadYamlByte(data []byte) (common.Fields, error) {
 	if err != nil {
 		return nil, err
 	}
-	cfg.Unpack(&keys)
+	if err := cfg.Unpack(&keys); err != nil {
+		return nil, err
+	}
 
 	fields := common.Fields{}
 
@@ -179,3 +179,141 @@ func TestAppendFields(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateMapping(t *testing.T) {
+	fields := common.Fields{
+		common.Field{Name: "message", Type: "text"},
+		common.Field{Name: "host", Type: "group", Fields: common.Fields{
+			common.Field{Name: "name", Type: "keyword"},
+			common.Field{Name: "hostname", Type: "alias", AliasPath: "host.name"},
+		}},
+		common.Field{Name: "load", Type: "scaled_float", ScalingFactor: 100},
+		common.Field{Name: "labels", Type: "object", ObjectType: "keyword"},
+	}
+
+	mapping, err := GenerateMapping(fields, "6.4.0")
+	assert.NoError(t, err)
+	assert.Equal(t, common.MapStr{
+		"properties": common.MapStr{
+			"message": common.MapStr{"type": "text", "norms": false},
+			"host": common.MapStr{
+				"properties": common.MapStr{
+					"name":     common.MapStr{"type": "keyword", "ignore_above": 1024},
+					"hostname": common.MapStr{"type": "alias", "path": "host.name"},
+				},
+			},
+			"labels": common.MapStr{"type": "object"},
+			"load":   common.MapStr{"type": "scaled_float", "scaling_factor": 100.0},
+		},
+		"dynamic_templates": []common.MapStr{
+			{
+				"labels": common.MapStr{
+					"mapping":            common.MapStr{"type": "keyword"},
+					"match_mapping_type": "string",
+					"path_match":         "labels.*",
+				},
+			},
+		},
+	}, mapping)
+
+	mapping, err = GenerateMapping(fields[:1], "2.4.0")
+	assert.NoError(t, err)
+	assert.Equal(t, common.MapStr{
+		"properties": common.MapStr{
+			"message": common.MapStr{"type": "string", "index": "analyzed", "norms": common.MapStr{"enabled": false}},
+		},
+	}, mapping)
+
+	_, err = GenerateMapping(fields, "invalid")
+	assert.Error(t, err)
+}
+
+func TestGenerateDynamicTemplates(t *testing.T) {
+	fields := common.Fields{
+		common.Field{Name: "message", Type: "text"},
+		common.Field{Name: "system", Type: "group", Fields: common.Fields{
+			common.Field{Name: "core.*.pct", Type: "object", ObjectType: "scaled_float", ScalingFactor: 100},
+		}},
+		common.Field{Name: "context", Type: "object", ObjectTypeParams: []common.ObjectTypeCfg{
+			{ObjectType: "long"},
+			{ObjectType: "keyword"},
+		}},
+	}
+
+	templates, err := DynamicTemplates(fields, "7.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, []common.MapStr{
+		{
+			"system.core.*.pct": common.MapStr{
+				"mapping":            common.MapStr{"type": "scaled_float", "scaling_factor": 100.0},
+				"match_mapping_type": "*",
+				"path_match":         "system.core.*.pct",
+			},
+		},
+		{
+			"context": common.MapStr{
+				"mapping":            common.MapStr{"type": "long"},
+				"match_mapping_type": "long",
+				"path_match":         "context.*",
+			},
+		},
+		{
+			"context": common.MapStr{
+				"mapping":            common.MapStr{"type": "keyword"},
+				"match_mapping_type": "string",
+				"path_match":         "context.*",
+			},
+		},
+	}, templates)
+
+	templates, err = DynamicTemplates(fields[:1], "7.0.0")
+	assert.NoError(t, err)
+	assert.Empty(t, templates)
+}
+
+func TestGenerateMappingOverrides(t *testing.T) {
+	fields := common.Fields{
+		common.Field{Name: "load", Type: "scaled_float", ScalingFactor: 100, Overrides: map[string]common.Field{
+			"v6": {Type: "float"},
+		}},
+	}
+
+	mapping, err := GenerateMapping(fields, "7.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, common.MapStr{"type": "scaled_float", "scaling_factor": 100.0}, mapping["properties"].(common.MapStr)["load"])
+
+	mapping, err = GenerateMapping(fields, "6.6.0")
+	assert.NoError(t, err)
+	assert.Equal(t, common.MapStr{"type": "float"}, mapping["properties"].(common.MapStr)["load"])
+}
+
+func TestLoadBytes(t *testing.T) {
+	ver := common.MustNewVersion("7.0.0")
+	template, err := New("7.0.0", "testbeat", *ver, TemplateConfig{})
+	assert.NoError(t, err)
+
+	data, err := template.LoadBytes([]byte(`
+- key: test
+  fields:
+    - name: message
+      type: string
+    - name: count
+      type: long
+`))
+	assert.NoError(t, err)
+	properties, err := data.GetValue("mappings._doc.properties")
+	assert.NoError(t, err)
+	// Deprecated types are kept as they are.
+	assert.Equal(t, common.MapStr{"type": "string"}, properties.(common.MapStr)["message"])
+	assert.Equal(t, common.MapStr{"type": "long"}, properties.(common.MapStr)["count"])
+
+	// Invalid fields are reported instead of generating an empty mapping.
+	_, err = template.LoadBytes([]byte(`
+- key: test
+  fields:
+    - name: count
+      type: long
+      analyzer: simple
+`))
+	assert.Error(t, err)
+}